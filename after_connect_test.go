@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"pap/internal/pgproto"
+)
+
+// queryServer accepts connections without authentication and completes every simple query, which is sent to
+// queries.
+func queryServer(t *testing.T, queries chan<- string) string {
+	return fakeServer(t, nil, func(b *pgproto.Backend) {
+		for {
+			msg, err := b.Receive()
+			if err != nil {
+				return
+			}
+			if q, ok := msg.(*pgproto.Query); ok {
+				queries <- q.String
+				b.Send(&pgproto.CommandComplete{CommandTag: []byte("SET")})
+				b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+			}
+		}
+	})
+}
+
+func TestAfterConnect(t *testing.T) {
+	queries := make(chan string, 4)
+	addr := queryServer(t, queries)
+	config, err := ParseConfig("postgres://u@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.AfterConnect = func(ctx context.Context, s Session) error {
+		_, err := s.Exec(ctx, "set search_path = app")
+		return err
+	}
+	cc, err := connectCheck(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.close()
+	if q := <-queries; q != "set search_path = app" {
+		t.Errorf("unexpected query %q", q)
+	}
+
+	errSetup := errors.New("setup failed")
+	config.AfterConnect = func(context.Context, Session) error {
+		return errSetup
+	}
+	if _, err := connectCheck(nil, config); !errors.Is(err, errSetup) {
+		t.Errorf("expected the error of AfterConnect, got %v", err)
+	}
+}
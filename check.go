@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"pap/internal/cfg"
+	"pap/internal/conn"
+	"pap/internal/pgtype"
+)
+
+var errCheckTimeout = errors.New("check timeout")
+
+type checkResult struct {
+	cc    *checkConn
+	value time.Duration
+	err   error
+}
+
+// runChecks runs check on a dedicated connection to the host of config every interval and passes the results to
+// report, until done is closed. A check that does not finish in the interval fails, the connection is replaced after a
+// failure.
+func runChecks(
+	done <-chan struct{},
+	connInfo *pgtype.ConnInfo,
+	config *cfg.Config,
+	interval time.Duration,
+	check func(cc *checkConn) (time.Duration, error),
+	report func(value time.Duration, err error),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var cc *checkConn
+	for {
+		result := make(chan checkResult, 1)
+		go func(cc *checkConn) {
+			var err error
+			if cc == nil {
+				cc, err = connectCheck(connInfo, config)
+				if err != nil {
+					result <- checkResult{err: err}
+					return
+				}
+			}
+			value, err := check(cc)
+			result <- checkResult{cc: cc, value: value, err: err}
+		}(cc)
+
+		var r checkResult
+		timer := time.NewTimer(interval)
+		select {
+		case r = <-result:
+			timer.Stop()
+		case <-timer.C:
+			r.err = errCheckTimeout
+			go closeCheckResult(result)
+		case <-done:
+			timer.Stop()
+			go closeCheckResult(result)
+			return
+		}
+
+		cc = r.cc
+		if r.err != nil && cc != nil {
+			cc.close()
+			cc = nil
+		}
+		report(r.value, r.err)
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			if cc != nil {
+				cc.close()
+			}
+			return
+		}
+	}
+}
+
+// closeCheckResult closes the connection of a check abandoned by runChecks when it finishes.
+func closeCheckResult(result <-chan checkResult) {
+	if r := <-result; r.cc != nil {
+		r.cc.close()
+	}
+}
+
+// checkConn is a dedicated connection of the replica health check and the latency measurement.
+type checkConn struct {
+	commandChan chan conn.Command
+	readyChan   chan int
+	q           *conn.Query
+}
+
+func connectCheck(connInfo *pgtype.ConnInfo, config *cfg.Config) (*checkConn, error) {
+	cc := &checkConn{
+		commandChan: make(chan conn.Command, 1),
+		readyChan:   make(chan int, 1),
+		q:           conn.NewQuery(connInfo, nil),
+	}
+	conn.Start(listenNumberFirstConn+int(atomic.AddInt64(&listenNumber, 1)-1), cc.commandChan, cc.readyChan, nil, nil)
+
+	cc.q.Mutex.Lock()
+	cc.commandChan <- conn.Command{
+		CommandType: conn.CommandConnect,
+		Query:       cc.q,
+		Body:        config.Copy(),
+	}
+	cc.q.Mutex.Lock()
+	err := cc.q.R.Error()
+	cc.q.Mutex.Unlock()
+	if err != nil {
+		cc.close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// query executes sql with the simple query protocol and returns the only value of its result.
+func (cc *checkConn) query(sql string) ([]byte, error) {
+	cc.q.Mutex.Lock()
+	if err := cc.q.Start(sql); err != nil {
+		cc.q.Mutex.Unlock()
+		return nil, err
+	}
+	<-cc.readyChan
+	cc.commandChan <- conn.Command{
+		CommandType: conn.CommandSimpleQuery,
+		Query:       cc.q,
+	}
+	cc.q.Mutex.Lock()
+	defer cc.q.Mutex.Unlock()
+
+	if err := cc.q.R.Error(); err != nil {
+		return nil, err
+	}
+	values := cc.q.R.RowValues()
+	if len(values) != 1 {
+		return nil, fmt.Errorf("unexpected result of %s", sql)
+	}
+	return values[0], nil
+}
+
+func (cc *checkConn) close() {
+	cc.commandChan <- conn.Command{CommandType: conn.CommandDisconnect}
+}
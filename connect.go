@@ -5,6 +5,7 @@
 package pap
 
 import (
+	"pap/internal/cfg"
 	"pap/internal/conn"
 )
 
@@ -13,11 +14,23 @@ func (p *Pap) connect(count int) {
 	defer p.conns.mutex.Unlock()
 	for i := 0; i < count; i++ {
 		if p.conns.list[i].status == connStatusOffline {
+			p.conns.list[i].setLifetime(p.config.MaxConnLifetime, p.config.MaxConnLifetimeJitter)
 			p.conns.list[i].commandChan <- conn.Command{
 				CommandType: conn.CommandConnect,
-				Body:        p.config.Copy(),
+				Body:        p.connConfig(i),
 			}
 			p.conns.list[i].status = connStatusOnline
 		}
 	}
 }
+
+// connConfig returns the config of the connection number. The connections of the replica pool are spread over the
+// replicas and set up by the AfterConnect of the pool.
+func (p *Pap) connConfig(number int) *cfg.Config {
+	if p.health != nil {
+		config := p.health.hosts[number%len(p.health.hosts)].config.Copy()
+		config.AfterConnect = p.config.AfterConnect
+		return config
+	}
+	return p.config.Copy()
+}
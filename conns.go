@@ -1,7 +1,10 @@
 package pap
 
 import (
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"pap/internal/conn"
 )
@@ -16,7 +19,65 @@ type connections struct {
 	list  []connection
 }
 
+// connection is a connection of the pool. The timestamps are in Unix nanoseconds and accessed atomically, they are
+// first in the struct for the 64-bit alignment of atomic operations.
 type connection struct {
+	created   int64 // the time the connection was (re)connected, see conn.Start
+	idleSince int64 // the time the connection completed its last command, zero while it executes one, see conn.Start
+	lifetime  int64 // the max lifetime with jitter, zero without max lifetime
+
 	commandChan chan conn.Command
 	status      int
+
+	mutex  sync.Mutex // serializes the sends with pinned
+	pinned bool       // a transaction is pinned to the connection
+}
+
+// send sends cmd to the connection taken from the ready connections unless a transaction is pinned to it. A ready
+// announcement made before the connection was pinned may still be queued, such an announcement is dropped this way.
+// cmd with Pin pins the connection. send returns false if the connection is pinned.
+func (c *connection) send(cmd conn.Command) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.pinned {
+		return false
+	}
+	c.pinned = cmd.Pin
+	c.commandChan <- cmd
+	return true
+}
+
+// sendPinned sends cmd of the transaction pinned to the connection, cmd with Unpin releases the connection.
+func (c *connection) sendPinned(cmd conn.Command) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pinned = !cmd.Unpin
+	c.commandChan <- cmd
+}
+
+// sendAny sends cmd regardless of a pinned transaction, e.g. to prepare a statement on every connection.
+func (c *connection) sendAny(cmd conn.Command) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.commandChan <- cmd
+}
+
+// setLifetime sets the max lifetime of the connection to maxLifetime with a random part of jitter, so that connections
+// connected together are not retired together. The connection records the time it was (re)connected in created.
+func (c *connection) setLifetime(maxLifetime, jitter time.Duration) {
+	lifetime := maxLifetime
+	if maxLifetime > 0 && jitter > 0 {
+		lifetime += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	atomic.StoreInt64(&c.lifetime, int64(lifetime))
+}
+
+// expired reports whether the connection exceeded its max lifetime or maxIdleTime at now. The idle time counts from
+// the completion of the last command, a connection executing a command is not idle.
+func (c *connection) expired(now time.Time, maxIdleTime time.Duration) bool {
+	if lifetime := atomic.LoadInt64(&c.lifetime); lifetime > 0 && now.UnixNano()-atomic.LoadInt64(&c.created) >= lifetime {
+		return true
+	}
+	idleSince := atomic.LoadInt64(&c.idleSince)
+	return maxIdleTime > 0 && idleSince > 0 && now.UnixNano()-idleSince >= int64(maxIdleTime)
 }
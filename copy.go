@@ -0,0 +1,370 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"pap/internal/conn"
+	"pap/internal/pgtype"
+)
+
+// COPY formats of CopyFromReader
+const (
+	CopyFormatText   = "text"
+	CopyFormatCSV    = "csv"
+	CopyFormatBinary = "binary"
+)
+
+const copyBufferLen = 65536
+
+var ErrCopySource = errors.New("copy source must be a CopyFromSource, a *CopyFromReader or an io.Reader")
+
+// CopyFromSource is a row iterator used as the source of CopyFrom.
+type CopyFromSource interface {
+	// Next returns true if there is another row and makes the next row data available to Values(). When there are
+	// no more rows available or an error has occurred it returns false.
+	Next() bool
+
+	// Values returns the values for the current row.
+	Values() ([]interface{}, error)
+
+	// Err returns any error that has been encountered by the CopyFromSource. If this is not nil CopyFrom will abort
+	// the copy.
+	Err() error
+}
+
+// CopyFromReader is a source of CopyFrom with data already in the COPY format.
+type CopyFromReader struct {
+	Reader io.Reader
+	Format string // CopyFormatText, CopyFormatCSV or CopyFormatBinary, empty means text
+	Header bool   // the first line of csv data is a header
+}
+
+type copyFromRows struct {
+	rows [][]interface{}
+	idx  int
+}
+
+// CopyFromRows returns a CopyFromSource interface over the provided rows slice making it usable by CopyFrom.
+func CopyFromRows(rows [][]interface{}) CopyFromSource {
+	return &copyFromRows{rows: rows, idx: -1}
+}
+
+func (ctr *copyFromRows) Next() bool {
+	ctr.idx++
+	return ctr.idx < len(ctr.rows)
+}
+
+func (ctr *copyFromRows) Values() ([]interface{}, error) {
+	return ctr.rows[ctr.idx], nil
+}
+
+func (ctr *copyFromRows) Err() error {
+	return nil
+}
+
+type copyFromSlice struct {
+	next func(int) ([]interface{}, error)
+	idx  int
+	len  int
+	err  error
+}
+
+// CopyFromSlice returns a CopyFromSource interface over a dynamic func making it usable by CopyFrom.
+func CopyFromSlice(length int, next func(int) ([]interface{}, error)) CopyFromSource {
+	return &copyFromSlice{next: next, idx: -1, len: length}
+}
+
+func (cts *copyFromSlice) Next() bool {
+	cts.idx++
+	return cts.idx < cts.len
+}
+
+func (cts *copyFromSlice) Values() ([]interface{}, error) {
+	values, err := cts.next(cts.idx)
+	if err != nil {
+		cts.err = err
+	}
+	return values, err
+}
+
+func (cts *copyFromSlice) Err() error {
+	return cts.err
+}
+
+// CopyFrom copies the rows of source into columns of table with COPY FROM STDIN and returns the number of copied
+// rows. table may be schema qualified as schema.table. source is a CopyFromSource, a *CopyFromReader or an io.Reader
+// with data in the text format. The rows of a CopyFromSource are encoded in the binary format when every destination
+// column type has a binary encoder registered in the ConnInfo, and in the text format otherwise.
+//
+// The data is streamed in chunks on one connection of the pool. The copy is aborted when ctx is done, but a blocked
+// read of a reader is not interrupted.
+func (p *Pap) CopyFrom(ctx context.Context, table string, columns []string, source interface{}) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var options string
+	var reader io.Reader
+	switch src := source.(type) {
+	case CopyFromSource:
+		oids, err := p.columnOIDs(ctx, table, columns)
+		if err != nil {
+			return 0, err
+		}
+
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		if binaryEncodable(p.config.ConnInfo, oids) {
+			options = " with (format binary)"
+			go func() {
+				pw.CloseWithError(p.encodeCopyBinary(ctx, pw, src, oids))
+			}()
+		} else {
+			go func() {
+				pw.CloseWithError(p.encodeCopyText(ctx, pw, src, len(columns)))
+			}()
+		}
+		reader = pr
+	case *CopyFromReader:
+		switch src.Format {
+		case "", CopyFormatText:
+		case CopyFormatCSV:
+			options = " with (format csv"
+			if src.Header {
+				options += ", header"
+			}
+			options += ")"
+		case CopyFormatBinary:
+			options = " with (format binary)"
+		default:
+			return 0, fmt.Errorf("unknown copy format %s", src.Format)
+		}
+		reader = src.Reader
+	case io.Reader:
+		reader = src
+	default:
+		return 0, ErrCopySource
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("copy ")
+	sb.WriteString(quoteQualifiedIdentifier(table))
+	sb.WriteString(" (")
+	for i := range columns {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(conn.QuoteIdentifier(columns[i]))
+	}
+	sb.WriteString(") from stdin")
+	sb.WriteString(options)
+
+	actx, release, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	eq, err := p.startQuery(actx, false, sb.String())
+	if err != nil {
+		release()
+		return 0, err
+	}
+	_, err = p.dispatchQuery(actx, false, conn.Command{
+		CommandType: conn.CommandCopyFrom,
+		Query:       eq,
+		Body: &conn.CopyFrom{
+			SQL:    eq.SQL,
+			Reader: reader,
+			Done:   ctx.Done(),
+		},
+	})
+	release()
+	if err != nil {
+		return 0, err
+	}
+
+	eq.Mutex.Lock()
+	defer eq.Close()
+	if err := eq.R.Error(); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, err
+	}
+
+	return eq.R.CommandTag().RowsAffected(), nil
+}
+
+// quoteQualifiedIdentifier quotes every dot separated part of s.
+func quoteQualifiedIdentifier(s string) string {
+	parts := strings.Split(s, ".")
+	for i := range parts {
+		parts[i] = conn.QuoteIdentifier(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+// encodeCopyText writes the rows of src to w in the COPY text format.
+func (p *Pap) encodeCopyText(ctx context.Context, w io.Writer, src CopyFromSource, columns int) error {
+	bw := bufio.NewWriterSize(w, copyBufferLen)
+	// buf stays non-nil for empty values, nil is NULL
+	buf := make([]byte, 0, 64)
+
+	for src.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		values, err := src.Values()
+		if err != nil {
+			return err
+		}
+		if len(values) != columns {
+			return fmt.Errorf("expected %d values, got %d values", columns, len(values))
+		}
+
+		for i := range values {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+
+			value, err := encodeCopyTextValue(p.config.ConnInfo, buf[:0], values[i])
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				bw.WriteString(`\N`)
+				continue
+			}
+			writeCopyTextEscaped(bw, value)
+			buf = value
+		}
+
+		if _, err := bw.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+
+	if err := src.Err(); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// encodeCopyTextValue appends the text format of value to buf. nil is returned for NULL.
+func encodeCopyTextValue(ci *pgtype.ConnInfo, buf []byte, value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	refVal := reflect.ValueOf(value)
+	if refVal.Kind() == reflect.Ptr {
+		if refVal.IsNil() {
+			return nil, nil
+		}
+		if _, ok := value.(pgtype.TextEncoder); !ok {
+			if _, ok := value.(driver.Valuer); !ok {
+				return encodeCopyTextValue(ci, buf, refVal.Elem().Interface())
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return append(buf, v...), nil
+	case pgtype.TextEncoder:
+		return v.EncodeText(ci, buf)
+	case driver.Valuer:
+		dv, err := v.Value()
+		if err != nil {
+			return nil, err
+		}
+		return encodeCopyTextValue(ci, buf, dv)
+	}
+
+	if dt, ok := ci.DataTypeForValue(value); ok {
+		tv := pgtype.NewValue(dt.Value)
+		if te, ok := tv.(pgtype.TextEncoder); ok {
+			if err := tv.Set(value); err != nil {
+				return nil, err
+			}
+			return te.EncodeText(ci, buf)
+		}
+	}
+
+	return nil, conn.SerializationError(fmt.Sprintf("Cannot encode %T in the COPY text format", value))
+}
+
+// writeCopyTextEscaped writes buf with the backslash escapes of the COPY text format.
+func writeCopyTextEscaped(bw *bufio.Writer, buf []byte) {
+	for _, b := range buf {
+		switch b {
+		case '\\':
+			bw.WriteString(`\\`)
+		case '\t':
+			bw.WriteString(`\t`)
+		case '\n':
+			bw.WriteString(`\n`)
+		case '\r':
+			bw.WriteString(`\r`)
+		default:
+			bw.WriteByte(b)
+		}
+	}
+}
+
+// CopyTo executes the COPY ... TO STDOUT statement sql, writes its data to w and returns the number of copied rows.
+// The data is streamed as it is received on one connection of the pool, it is not buffered in the result.
+//
+// When ctx is done or writing to w fails, the statement is canceled on the server and the rest of the data is
+// discarded. A blocked write to w is not interrupted.
+func (p *Pap) CopyTo(ctx context.Context, w io.Writer, sql string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	actx, release, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	eq, err := p.startQuery(actx, false, sql)
+	if err != nil {
+		release()
+		return 0, err
+	}
+	_, err = p.dispatchQuery(actx, false, conn.Command{
+		CommandType: conn.CommandCopyTo,
+		Query:       eq,
+		Body: &conn.CopyTo{
+			SQL:    eq.SQL,
+			Writer: w,
+			Done:   ctx.Done(),
+		},
+	})
+	release()
+	if err != nil {
+		return 0, err
+	}
+
+	eq.Mutex.Lock()
+	defer eq.Close()
+	if err := eq.R.Error(); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, err
+	}
+
+	return eq.R.CommandTag().RowsAffected(), nil
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+
+	"pap/internal/conn"
+	"pap/internal/pgtype"
+)
+
+// copyBinaryHeader is the signature followed by the flags field and the header extension area length.
+var copyBinaryHeader = []byte{'P', 'G', 'C', 'O', 'P', 'Y', '\n', 0377, '\r', '\n', 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+type pgAttribute struct {
+	Name string
+	OID  uint32
+}
+
+// columnOIDs returns the type OIDs of columns of table.
+func (p *Pap) columnOIDs(ctx context.Context, table string, columns []string) ([]uint32, error) {
+	var attributes []pgAttribute
+	err := p.QueryAsyncContext(
+		ctx,
+		`select attname::text, atttypid
+from pg_attribute
+where attrelid = $1::text::regclass and attnum > 0 and not attisdropped`,
+		quoteQualifiedIdentifier(table),
+	)(&attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	oids := make([]uint32, len(columns))
+	for i := range columns {
+		for j := range attributes {
+			if attributes[j].Name == columns[i] {
+				oids[i] = attributes[j].OID
+				break
+			}
+		}
+		if oids[i] == 0 {
+			return nil, fmt.Errorf("column %s of %s not found", columns[i], table)
+		}
+	}
+
+	return oids, nil
+}
+
+// binaryEncodable reports whether values of every type in oids can be encoded in the binary format.
+func binaryEncodable(ci *pgtype.ConnInfo, oids []uint32) bool {
+	for i := range oids {
+		dt, ok := ci.DataTypeForOID(oids[i])
+		if !ok {
+			return false
+		}
+		if _, ok := dt.Value.(pgtype.BinaryEncoder); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeCopyBinary writes the rows of src to w in the COPY binary format. The values are encoded by the binary
+// encoders of the column types oids.
+func (p *Pap) encodeCopyBinary(ctx context.Context, w io.Writer, src CopyFromSource, oids []uint32) error {
+	bw := bufio.NewWriterSize(w, copyBufferLen)
+	buf := make([]byte, 0, 64)
+	lenBuf := make([]byte, 4)
+
+	bw.Write(copyBinaryHeader)
+
+	for src.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		values, err := src.Values()
+		if err != nil {
+			return err
+		}
+		if len(values) != len(oids) {
+			return fmt.Errorf("expected %d values, got %d values", len(oids), len(values))
+		}
+
+		binary.BigEndian.PutUint16(lenBuf, uint16(len(values)))
+		bw.Write(lenBuf[:2])
+
+		for i := range values {
+			value, err := encodeBinaryValue(p.config.ConnInfo, buf[:0], oids[i], values[i])
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				binary.BigEndian.PutUint32(lenBuf, 0xFFFFFFFF)
+				bw.Write(lenBuf)
+				continue
+			}
+			binary.BigEndian.PutUint32(lenBuf, uint32(len(value)))
+			bw.Write(lenBuf)
+			if _, err := bw.Write(value); err != nil {
+				return err
+			}
+			buf = value
+		}
+	}
+
+	if err := src.Err(); err != nil {
+		return err
+	}
+
+	// trailer
+	binary.BigEndian.PutUint16(lenBuf, 0xFFFF)
+	bw.Write(lenBuf[:2])
+
+	return bw.Flush()
+}
+
+// encodeBinaryValue encodes value in the binary format of oid into buf, which must be empty. nil is returned for
+// NULL.
+func encodeBinaryValue(ci *pgtype.ConnInfo, buf []byte, oid uint32, value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	refVal := reflect.ValueOf(value)
+	if refVal.Kind() == reflect.Ptr && refVal.IsNil() {
+		return nil, nil
+	}
+
+	dt, ok := ci.DataTypeForOID(oid)
+	if !ok {
+		return nil, conn.SerializationError(fmt.Sprintf("no data type registered for oid %d", oid))
+	}
+
+	switch v := value.(type) {
+	case pgtype.Value:
+		// a value of the column type encodes itself, other values are converted by the column type
+		if encoder, ok := v.(pgtype.BinaryEncoder); ok && indirectType(v) == indirectType(dt.Value) {
+			return encoder.EncodeBinary(ci, buf)
+		}
+		if value = v.Get(); value == nil {
+			return nil, nil
+		}
+		refVal = reflect.ValueOf(value)
+	case driver.Valuer:
+		dv, err := v.Value()
+		if err != nil {
+			return nil, err
+		}
+		return encodeBinaryValue(ci, buf, oid, dv)
+	}
+
+	dv := pgtype.NewValue(dt.Value)
+	if err := dv.Set(value); err != nil {
+		if refVal.Kind() == reflect.Ptr {
+			return encodeBinaryValue(ci, buf, oid, refVal.Elem().Interface())
+		}
+		return nil, err
+	}
+
+	encoder, ok := dv.(pgtype.BinaryEncoder)
+	if !ok {
+		return nil, conn.SerializationError(fmt.Sprintf("Cannot encode %T into oid %d in the binary format", value, oid))
+	}
+	return encoder.EncodeBinary(ci, buf)
+}
+
+// indirectType returns the type of v, or the type v points to.
+func indirectType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
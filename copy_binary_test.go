@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"pap/internal/pgtype"
+)
+
+func TestEncodeCopyBinary(t *testing.T) {
+	p := &Pap{}
+	p.config.ConnInfo = pgtype.NewConnInfo()
+
+	name := "b"
+	rows := [][]interface{}{
+		{int64(1), "a"},
+		{2, &name},
+		{int32(3), nil},
+		{int64(4), ""},
+	}
+
+	var buf bytes.Buffer
+	err := p.encodeCopyBinary(context.Background(), &buf, CopyFromRows(rows), []uint32{pgtype.Int8OID, pgtype.TextOID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("PGCOPY\n\377\r\n\000")
+	expected = append(expected, 0, 0, 0, 0, 0, 0, 0, 0)
+	expected = append(expected, 0, 2, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 'a')
+	expected = append(expected, 0, 2, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 'b')
+	expected = append(expected, 0, 2, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 3, 0xFF, 0xFF, 0xFF, 0xFF)
+	expected = append(expected, 0, 2, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0)
+	expected = append(expected, 0xFF, 0xFF)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("expected %v, got %v", expected, buf.Bytes())
+	}
+}
+
+func TestBinaryEncodable(t *testing.T) {
+	ci := pgtype.NewConnInfo()
+	if !binaryEncodable(ci, []uint32{pgtype.Int8OID, pgtype.TextOID}) {
+		t.Fatal("expected int8 and text to be binary encodable")
+	}
+	if binaryEncodable(ci, []uint32{pgtype.Int8OID, 1}) {
+		t.Fatal("expected unknown oid not to be binary encodable")
+	}
+}
+
+func TestEncodeBinaryValueColumnType(t *testing.T) {
+	ci := pgtype.NewConnInfo()
+
+	value, err := encodeBinaryValue(ci, nil, pgtype.Int8OID, &pgtype.Int4{Int: 5, Status: pgtype.Present})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 0, 0, 0, 0, 0, 0, 5}; !bytes.Equal(value, expected) {
+		t.Errorf("expected int4 encoded as int8 %v, got %v", expected, value)
+	}
+
+	value, err = encodeBinaryValue(ci, nil, pgtype.Int8OID, pgtype.Int8{Int: 6, Status: pgtype.Present})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 0, 0, 0, 0, 0, 0, 6}; !bytes.Equal(value, expected) {
+		t.Errorf("expected %v, got %v", expected, value)
+	}
+
+	value, err = encodeBinaryValue(ci, nil, pgtype.Int8OID, &pgtype.Int4{Status: pgtype.Null})
+	if err != nil || value != nil {
+		t.Errorf("expected NULL, got %v, %v", value, err)
+	}
+
+	box := &pgtype.Box{P: [2]pgtype.Vec2{{X: 1, Y: 1}, {}}, Status: pgtype.Present}
+	if _, err := encodeBinaryValue(ci, nil, pgtype.Int8OID, box); err == nil {
+		t.Error("expected error for a box encoded as int8")
+	}
+}
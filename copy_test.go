@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+func TestEncodeCopyText(t *testing.T) {
+	p := &Pap{}
+	p.config.ConnInfo = pgtype.NewConnInfo()
+
+	name := "b\\c"
+	rows := [][]interface{}{
+		{int64(1), "a\tb\nc", nil},
+		{int32(2), "", &name},
+		{3, (*string)(nil), time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+
+	var buf bytes.Buffer
+	err := p.encodeCopyText(context.Background(), &buf, CopyFromRows(rows), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "1\ta\\tb\\nc\t\\N\n" +
+		"2\t\tb\\\\c\n" +
+		"3\t\\N\t2022-01-02 03:04:05Z\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestEncodeCopyTextValuesCount(t *testing.T) {
+	p := &Pap{}
+	p.config.ConnInfo = pgtype.NewConnInfo()
+
+	var buf bytes.Buffer
+	err := p.encodeCopyText(context.Background(), &buf, CopyFromRows([][]interface{}{{1, 2}}), 3)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// copyServer is a fake server answering COPY FROM STDIN after release is closed with the number of received lines,
+// which are appended to received. COPY TO STDOUT sends the lines of sent, the last after release is closed. Other
+// queries return 1, see serveQueries.
+type copyServer struct {
+	release chan struct{}
+	sent    []string
+
+	mutex    sync.Mutex
+	received bytes.Buffer
+}
+
+func startCopyServer(t *testing.T) (*copyServer, *Pap) {
+	s := &copyServer{release: make(chan struct{})}
+	addr := fakeServer(t, nil, s.serve)
+	p, err := Start("postgres://u@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, p
+}
+
+func (s *copyServer) serve(b *pgproto.Backend) {
+	serveQueries(b, func(sql string, args [][]byte) fakeResult {
+		if strings.HasSuffix(sql, "from stdin") {
+			return fakeResult{serve: s.copyIn}
+		}
+		if strings.HasSuffix(sql, "to stdout") {
+			return fakeResult{serve: s.copyOut}
+		}
+		return fakeResult{oids: []uint32{pgtype.Int4OID}, rows: [][]interface{}{{int32(1)}}}
+	})
+}
+
+func (s *copyServer) copyIn(b *pgproto.Backend) {
+	b.Send(&pgproto.CopyInResponse{})
+	var data []byte
+	for done := false; !done; {
+		msg, err := b.Receive()
+		if err != nil {
+			return
+		}
+		switch msg := msg.(type) {
+		case *pgproto.CopyData:
+			data = append(data, msg.Data...)
+		case *pgproto.CopyDone:
+			done = true
+		}
+	}
+
+	<-s.release
+	s.mutex.Lock()
+	s.received.Write(data)
+	s.mutex.Unlock()
+	b.Send(&pgproto.CommandComplete{CommandTag: []byte("COPY " + strconv.Itoa(bytes.Count(data, []byte{'\n'})))})
+}
+
+func (s *copyServer) copyOut(b *pgproto.Backend) {
+	b.Send(&pgproto.CopyOutResponse{})
+	for i, line := range s.sent {
+		if i == len(s.sent)-1 {
+			<-s.release
+		}
+		b.Send(&pgproto.CopyData{Data: []byte(line)})
+	}
+	b.Send(&pgproto.CopyDone{})
+	b.Send(&pgproto.CommandComplete{CommandTag: []byte("COPY " + strconv.Itoa(len(s.sent)))})
+}
+
+// waitQueries runs sequential queries on p, enough to use every ready connection, and fails unless they complete in
+// time.
+func waitQueries(t *testing.T, p *Pap) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2*max; i++ {
+			var rows []struct {
+				N int32
+			}
+			if err := p.QueryAsync("select 1")(&rows); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queries are not answered in time")
+	}
+}
+
+func TestCopyFromReader(t *testing.T) {
+	s, p := startCopyServer(t)
+
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := p.CopyFrom(context.Background(), "s.t", []string{"a"}, strings.NewReader("1\n2\n"))
+		done <- result{n, err}
+	}()
+
+	// the connection of the copy is not announced before the copy is done
+	waitQueries(t, p)
+	close(s.release)
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.n != 2 {
+		t.Errorf("expected 2 copied rows, got %d", r.n)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.received.String() != "1\n2\n" {
+		t.Errorf("unexpected data %q", s.received.String())
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	s, p := startCopyServer(t)
+	s.sent = []string{"1\ta\n", "2\tb\n"}
+
+	type result struct {
+		n   int64
+		err error
+	}
+	var buf bytes.Buffer
+	done := make(chan result, 1)
+	go func() {
+		n, err := p.CopyTo(context.Background(), &buf, "copy t to stdout")
+		done <- result{n, err}
+	}()
+
+	// the connection of the copy is not announced before the copy is done
+	waitQueries(t, p)
+	close(s.release)
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.n != 2 {
+		t.Errorf("expected 2 copied rows, got %d", r.n)
+	}
+	if buf.String() != "1\ta\n2\tb\n" {
+		t.Errorf("unexpected data %q", buf.String())
+	}
+
+	if _, err := p.CopyTo(context.Background(), &buf, "select 1"); err == nil {
+		t.Error("expected error for a statement which is not COPY TO STDOUT")
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+// states of the preferred host of TestFailback
+const (
+	hostDown = iota // rejects connections
+	hostHang        // stalls connections until it is up, then rejects them
+	hostUp
+)
+
+// hostServer starts a fake server whose queries return name. auth authenticates its connections, see fakeServer. open
+// counts the connections being served.
+func hostServer(t *testing.T, name string, auth func(b *pgproto.Backend) bool, open *int32) string {
+	return fakeServer(t, auth, func(b *pgproto.Backend) {
+		atomic.AddInt32(open, 1)
+		defer atomic.AddInt32(open, -1)
+		serveQueries(b, func(sql string, args [][]byte) fakeResult {
+			return fakeResult{oids: []uint32{pgtype.TextOID}, rows: [][]interface{}{{name}}}
+		})
+	})
+}
+
+// queryHost returns the name of the host answering a query.
+func queryHost(t *testing.T, p *Pap) string {
+	t.Helper()
+	var rows []struct {
+		Host string
+	}
+	if err := p.QueryAsync("select host")(&rows); err != nil {
+		t.Fatal(err)
+	}
+	return rows[0].Host
+}
+
+// TestFailback checks that the connections move back to the preferred host when it is reachable again, and that
+// the queries are not delayed by a preferred host that does not respond meanwhile.
+func TestFailback(t *testing.T) {
+	var state int32
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	var openPreferred, openFallback int32
+	preferred := hostServer(t, "preferred", func(b *pgproto.Backend) bool {
+		switch atomic.LoadInt32(&state) {
+		case hostDown:
+			return false
+		case hostHang:
+			<-release
+			return false
+		}
+		return true
+	}, &openPreferred)
+	fallback := hostServer(t, "fallback", nil, &openFallback)
+
+	config, err := ParseConfig("postgres://u@" + preferred + "," + fallback + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.FailbackInterval = 20 * time.Millisecond
+	p, err := StartConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host := queryHost(t, p); host != "fallback" {
+		t.Fatalf("expected the fallback host, got %s", host)
+	}
+
+	// the failback attempts hang in the background
+	atomic.StoreInt32(&state, hostHang)
+	time.Sleep(10 * config.FailbackInterval)
+	done := make(chan string, 1)
+	go func() {
+		for i := 0; i < 2*max; i++ {
+			var rows []struct {
+				Host string
+			}
+			if err := p.QueryAsync("select host")(&rows); err != nil || rows[0].Host != "fallback" {
+				done <- fmt.Sprintf("expected the fallback host, got %v, %v", rows, err)
+				return
+			}
+		}
+		done <- ""
+	}()
+	select {
+	case msg := <-done:
+		if msg != "" {
+			t.Fatal(msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queries delayed by the failback")
+	}
+
+	// the connections move back and the connections to the fallback host are closed
+	atomic.StoreInt32(&state, hostUp)
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&openPreferred) < 10 || atomic.LoadInt32(&openFallback) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no failback: %d connections to the preferred host, %d to the fallback host",
+				atomic.LoadInt32(&openPreferred), atomic.LoadInt32(&openFallback))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 2*max; i++ {
+		if host := queryHost(t, p); host != "preferred" {
+			t.Fatalf("expected the preferred host after the failback, got %s", host)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"pap/internal/conn"
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+var ErrFunctionRef = errors.New("function must be a name or an oid of type uint32")
+
+type function struct {
+	oid       uint32
+	resultOID uint32
+	argOIDs   []uint32
+}
+
+type functions struct {
+	byName map[string]*function
+	byOID  map[uint32]*function
+	mutex  sync.RWMutex
+}
+
+type pgProc struct {
+	OID       uint32
+	ResultOID uint32
+	ArgOIDs   string
+}
+
+// CallFunction calls a function with the fast-path interface and returns its result decoded by the data type of the
+// result type, or nil for NULL. fn is the name of the function, with argument types if it is overloaded, e.g.
+// "lo_lseek64(int4, int8, int4)", or its oid as uint32. The oid, argument and result types are resolved once and
+// cached.
+//
+// The arguments are encoded in the binary format of the argument types.
+func (p *Pap) CallFunction(ctx context.Context, fn interface{}, args ...interface{}) (interface{}, error) {
+	return p.callFunction(ctx, -1, fn, args)
+}
+
+// callFunction calls fn on the connection number, or on a ready connection of the pool if number is negative.
+func (p *Pap) callFunction(ctx context.Context, number int, fn interface{}, args []interface{}) (interface{}, error) {
+	f, err := p.function(ctx, fn)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(f.argOIDs) {
+		return nil, fmt.Errorf("function %v expects %d arguments, got %d", fn, len(f.argOIDs), len(args))
+	}
+
+	fc, resultDT, err := p.functionCall(f, args)
+	if err != nil {
+		return nil, err
+	}
+
+	eq, err := p.sendFunctionCall(ctx, number, fc)
+	if err != nil {
+		return nil, err
+	}
+
+	eq.Mutex.Lock()
+	defer eq.Close()
+	if err := eq.R.Error(); err != nil {
+		return nil, err
+	}
+	if len(eq.R.RowValues()) == 0 {
+		return nil, errors.New("no function call response")
+	}
+
+	return decodeFunctionResult(p.config.ConnInfo, resultDT, fc.ResultFormatCode, eq.R.RowValues()[0])
+}
+
+// sendFunctionCall sends fc to the connection number, or to a ready connection of the pool if number is negative, and
+// returns the query to wait for its result. ctx limits the waiting for a query and a connection.
+func (p *Pap) sendFunctionCall(ctx context.Context, number int, fc *pgproto.FunctionCall) (*conn.Query, error) {
+	var actx context.Context
+	var release func()
+	var err error
+	if number < 0 {
+		actx, release, err = p.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		actx, release = p.acquireContext(ctx)
+	}
+	defer release()
+
+	eq, err := p.startQuery(actx, false, "")
+	if err != nil {
+		return nil, err
+	}
+	cmd := conn.Command{
+		CommandType: conn.CommandFunctionCall,
+		Query:       eq,
+		Body:        fc,
+	}
+	if number < 0 {
+		if _, err := p.dispatchQuery(actx, false, cmd); err != nil {
+			return nil, err
+		}
+	} else {
+		p.conns.list[number].sendPinned(cmd)
+	}
+	return eq, nil
+}
+
+// functionCall builds the FunctionCall message of f. The result format is binary if the result data type supports
+// it. The returned data type is nil if no data type is registered for the result type.
+func (p *Pap) functionCall(f *function, args []interface{}) (*pgproto.FunctionCall, *pgtype.DataType, error) {
+	ci := p.config.ConnInfo
+	fc := &pgproto.FunctionCall{
+		Function:       f.oid,
+		ArgFormatCodes: []uint16{conn.BinaryFormatCode},
+		Arguments:      make([][]byte, len(args)),
+	}
+
+	for i := range args {
+		// the buffer stays non-nil for empty values, nil is NULL
+		value, err := encodeBinaryValue(ci, make([]byte, 0, 32), f.argOIDs[i], args[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		fc.Arguments[i] = value
+	}
+
+	resultDT, ok := ci.DataTypeForOID(f.resultOID)
+	if !ok {
+		return fc, nil, nil
+	}
+	if _, ok := resultDT.Value.(pgtype.BinaryDecoder); ok {
+		fc.ResultFormatCode = conn.BinaryFormatCode
+	}
+	return fc, resultDT, nil
+}
+
+// decodeFunctionResult decodes src by dt. The text of src is returned if dt is nil.
+func decodeFunctionResult(ci *pgtype.ConnInfo, dt *pgtype.DataType, format uint16, src []byte) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	if dt == nil {
+		return string(src), nil
+	}
+
+	v := pgtype.NewValue(dt.Value)
+	if format == conn.BinaryFormatCode {
+		if err := v.(pgtype.BinaryDecoder).DecodeBinary(ci, src); err != nil {
+			return nil, err
+		}
+		return v.Get(), nil
+	}
+
+	td, ok := v.(pgtype.TextDecoder)
+	if !ok {
+		return string(src), nil
+	}
+	if err := td.DecodeText(ci, src); err != nil {
+		return nil, err
+	}
+	return v.Get(), nil
+}
+
+// function returns the cached function fn, resolving it on the first call.
+func (p *Pap) function(ctx context.Context, fn interface{}) (*function, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.functions.mutex.RLock()
+	var f *function
+	switch fn := fn.(type) {
+	case string:
+		f = p.functions.byName[fn]
+	case uint32:
+		f = p.functions.byOID[fn]
+	default:
+		p.functions.mutex.RUnlock()
+		return nil, ErrFunctionRef
+	}
+	p.functions.mutex.RUnlock()
+	if f != nil {
+		return f, nil
+	}
+
+	var procs []pgProc
+	switch fn := fn.(type) {
+	case string:
+		cast := "regproc"
+		if strings.Contains(fn, "(") {
+			cast = "regprocedure"
+		}
+		err := p.QueryAsyncContext(
+			ctx,
+			"select oid, prorettype, array_to_string(proargtypes::oid[], ',') from pg_proc where oid = $1::text::"+cast,
+			fn,
+		)(&procs)
+		if err != nil {
+			return nil, err
+		}
+	case uint32:
+		err := p.QueryAsyncContext(
+			ctx,
+			"select oid, prorettype, array_to_string(proargtypes::oid[], ',') from pg_proc where oid = $1",
+			fn,
+		)(&procs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(procs) == 0 {
+		return nil, fmt.Errorf("function %v not found", fn)
+	}
+
+	f = &function{oid: procs[0].OID, resultOID: procs[0].ResultOID}
+	if procs[0].ArgOIDs != "" {
+		for _, s := range strings.Split(procs[0].ArgOIDs, ",") {
+			oid, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			f.argOIDs = append(f.argOIDs, uint32(oid))
+		}
+	}
+
+	p.functions.mutex.Lock()
+	switch fn := fn.(type) {
+	case string:
+		p.functions.byName[fn] = f
+	case uint32:
+		p.functions.byOID[fn] = f
+	}
+	p.functions.mutex.Unlock()
+
+	return f, nil
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"bytes"
+	"testing"
+
+	"pap/internal/conn"
+	"pap/internal/pgtype"
+)
+
+func TestFunctionCall(t *testing.T) {
+	p := &Pap{}
+	p.config.ConnInfo = pgtype.NewConnInfo()
+
+	f := &function{oid: 100, resultOID: pgtype.Int4OID, argOIDs: []uint32{pgtype.Int4OID, pgtype.ByteaOID, pgtype.TextOID}}
+	fc, dt, err := p.functionCall(f, []interface{}{1, []byte{}, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fc.Function != 100 || fc.ResultFormatCode != conn.BinaryFormatCode || dt == nil || dt.OID != pgtype.Int4OID {
+		t.Fatalf("unexpected function call %+v", fc)
+	}
+	if !bytes.Equal(fc.Arguments[0], []byte{0, 0, 0, 1}) {
+		t.Fatalf("unexpected first argument %v", fc.Arguments[0])
+	}
+	if fc.Arguments[1] == nil || len(fc.Arguments[1]) != 0 {
+		t.Fatalf("expected empty second argument, got %v", fc.Arguments[1])
+	}
+	if fc.Arguments[2] != nil {
+		t.Fatalf("expected NULL third argument, got %v", fc.Arguments[2])
+	}
+}
+
+func TestDecodeFunctionResult(t *testing.T) {
+	ci := pgtype.NewConnInfo()
+	dt, _ := ci.DataTypeForOID(pgtype.Int4OID)
+
+	result, err := decodeFunctionResult(ci, dt, conn.BinaryFormatCode, []byte{0, 0, 0, 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != int32(42) {
+		t.Fatalf("expected 42, got %v", result)
+	}
+
+	result, err = decodeFunctionResult(ci, dt, conn.BinaryFormatCode, nil)
+	if err != nil || result != nil {
+		t.Fatalf("expected NULL, got %v, %v", result, err)
+	}
+
+	result, err = decodeFunctionResult(ci, nil, conn.TextFormatCode, []byte("x"))
+	if err != nil || result != "x" {
+		t.Fatalf("expected text, got %v, %v", result, err)
+	}
+}
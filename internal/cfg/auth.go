@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Authentication methods of require_auth. AuthNone is a server that does not request authentication.
+const (
+	AuthPassword    = "password"
+	AuthMD5         = "md5"
+	AuthGSS         = "gss"
+	AuthSSPI        = "sspi"
+	AuthSCRAMSHA256 = "scram-sha-256"
+	AuthOAuth       = "oauth"
+	AuthNone        = "none"
+)
+
+var authMethods = []string{AuthPassword, AuthMD5, AuthGSS, AuthSSPI, AuthSCRAMSHA256, AuthOAuth, AuthNone}
+
+// AuthMethods is the set of authentication methods the server may request, parsed from require_auth like libpq does.
+// A nil AuthMethods allows all methods.
+type AuthMethods struct {
+	setting string
+	allowed map[string]bool
+}
+
+// ParseRequireAuth parses a comma separated list of authentication methods. If the methods are prefixed with "!",
+// all other methods are allowed. Negated and plain methods cannot be mixed. An empty setting returns nil.
+func ParseRequireAuth(setting string) (*AuthMethods, error) {
+	if setting == "" {
+		return nil, nil
+	}
+
+	m := &AuthMethods{setting: setting, allowed: make(map[string]bool, len(authMethods))}
+	listed := make(map[string]bool)
+	negated := strings.HasPrefix(setting, "!")
+	for _, method := range strings.Split(setting, ",") {
+		method = strings.TrimSpace(method)
+		if strings.HasPrefix(method, "!") != negated {
+			return nil, errors.New("negative require_auth methods cannot be mixed with positive ones")
+		}
+		method = strings.TrimPrefix(method, "!")
+
+		known := false
+		for _, authMethod := range authMethods {
+			known = known || method == authMethod
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown require_auth method: %q", method)
+		}
+		if listed[method] {
+			return nil, fmt.Errorf("require_auth method %q is listed more than once", method)
+		}
+		listed[method] = true
+	}
+
+	for _, method := range authMethods {
+		m.allowed[method] = listed[method] != negated
+	}
+	return m, nil
+}
+
+// Allows reports whether the server may authenticate with method.
+func (m *AuthMethods) Allows(method string) bool {
+	return m == nil || m.allowed[method]
+}
+
+// String returns the require_auth setting.
+func (m *AuthMethods) String() string {
+	if m == nil {
+		return ""
+	}
+	return m.setting
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"testing"
+)
+
+func TestParseRequireAuth(t *testing.T) {
+	tests := []struct {
+		setting string
+		allowed []string
+		denied  []string
+	}{
+		{"", []string{AuthPassword, AuthMD5, AuthSCRAMSHA256, AuthNone}, nil},
+		{"scram-sha-256", []string{AuthSCRAMSHA256}, []string{AuthPassword, AuthMD5, AuthNone}},
+		{"md5,scram-sha-256", []string{AuthMD5, AuthSCRAMSHA256}, []string{AuthPassword, AuthNone}},
+		{"!password", []string{AuthMD5, AuthSCRAMSHA256, AuthNone}, []string{AuthPassword}},
+		{"!password,!none", []string{AuthMD5, AuthSCRAMSHA256}, []string{AuthPassword, AuthNone}},
+		{"none", []string{AuthNone}, []string{AuthPassword, AuthMD5, AuthSCRAMSHA256}},
+	}
+
+	for _, tt := range tests {
+		m, err := ParseRequireAuth(tt.setting)
+		if err != nil {
+			t.Errorf("%q: %v", tt.setting, err)
+			continue
+		}
+		for _, method := range tt.allowed {
+			if !m.Allows(method) {
+				t.Errorf("%q does not allow %s", tt.setting, method)
+			}
+		}
+		for _, method := range tt.denied {
+			if m.Allows(method) {
+				t.Errorf("%q allows %s", tt.setting, method)
+			}
+		}
+	}
+
+	for _, setting := range []string{"unknown", "!password,md5", "md5,md5"} {
+		if _, err := ParseRequireAuth(setting); err == nil {
+			t.Errorf("expected error for %q", setting)
+		}
+	}
+}
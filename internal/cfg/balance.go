@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// load_balance_hosts policies. disable and random are libpq compatible.
+const (
+	LoadBalanceDisable          = "disable"           // the hosts are tried in order
+	LoadBalanceRandom           = "random"            // the hosts are tried in random order
+	LoadBalanceRoundRobin       = "round-robin"       // every connection starts with the next host
+	LoadBalanceLeastConnections = "least-connections" // the host with the fewest connections is tried first
+	LoadBalanceLatency          = "latency"           // a host is picked weighted by its measured latency
+)
+
+// Balancer orders the hosts of a connection attempt by the load_balance_hosts policy. It is shared by the copies of a
+// config, so its counters cover all connections created from it.
+type Balancer struct {
+	policy string
+	mutex  sync.Mutex
+	next   int
+	hosts  map[string]*hostStat
+	rand   *rand.Rand
+}
+
+type hostStat struct {
+	conns   int
+	latency time.Duration // zero if not measured, negative if unreachable
+}
+
+// NewBalancer returns a Balancer for policy, nil for LoadBalanceDisable.
+func NewBalancer(policy string) *Balancer {
+	if policy == LoadBalanceDisable {
+		return nil
+	}
+	return &Balancer{
+		policy: policy,
+		hosts:  make(map[string]*hostStat),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Policy returns the load_balance_hosts policy of b.
+func (b *Balancer) Policy() string {
+	return b.policy
+}
+
+// Order returns fallbacks ordered by the policy for a connection attempt. The fallbacks of a host, e.g. with different
+// TLS settings, stay together in their order. The attempt is counted as a connection to the first host until
+// Connected is called with the result, so concurrent attempts are spread too.
+func (b *Balancer) Order(fallbacks []*FallbackConfig) []*FallbackConfig {
+	groups := groupFallbacks(fallbacks)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(groups) > 1 {
+		groups = b.order(groups)
+	}
+	b.stat(groups[0][0]).conns++
+
+	ordered := make([]*FallbackConfig, 0, len(fallbacks))
+	for _, group := range groups {
+		ordered = append(ordered, group...)
+	}
+	return ordered
+}
+
+func (b *Balancer) order(groups [][]*FallbackConfig) [][]*FallbackConfig {
+	switch b.policy {
+	case LoadBalanceRandom:
+		b.rand.Shuffle(len(groups), func(i, j int) { groups[i], groups[j] = groups[j], groups[i] })
+	case LoadBalanceRoundRobin:
+		first := b.next % len(groups)
+		b.next++
+		groups = append(groups[first:], groups[:first]...)
+	case LoadBalanceLeastConnections:
+		sort.SliceStable(groups, func(i, j int) bool {
+			return b.stat(groups[i][0]).conns < b.stat(groups[j][0]).conns
+		})
+	case LoadBalanceLatency:
+		groups = b.orderByLatency(groups)
+	}
+	return groups
+}
+
+// orderByLatency picks the first host randomly weighted by the inverse of the latency, the other hosts follow by
+// latency. Not measured hosts get the mean weight, unreachable hosts are tried last.
+func (b *Balancer) orderByLatency(groups [][]*FallbackConfig) [][]*FallbackConfig {
+	weights := make([]float64, len(groups))
+	var sum float64
+	var measured int
+	for i, group := range groups {
+		if latency := b.stat(group[0]).latency; latency > 0 {
+			weights[i] = 1 / latency.Seconds()
+			sum += weights[i]
+			measured++
+		}
+	}
+	for i, group := range groups {
+		if b.stat(group[0]).latency == 0 {
+			weights[i] = 1
+			if measured > 0 {
+				weights[i] = sum / float64(measured)
+			}
+		}
+	}
+
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	first := 0
+	if total > 0 {
+		r := b.rand.Float64() * total
+		for i, w := range weights {
+			if r < w {
+				first = i
+				break
+			}
+			r -= w
+		}
+	}
+
+	ordered := append([][]*FallbackConfig{groups[first]}, groups[:first]...)
+	ordered = append(ordered, groups[first+1:]...)
+	rest := ordered[1:]
+	sort.SliceStable(rest, func(i, j int) bool {
+		li, lj := b.stat(rest[i][0]).latency, b.stat(rest[j][0]).latency
+		if (li < 0) != (lj < 0) {
+			return lj < 0
+		}
+		return li < lj
+	})
+	return ordered
+}
+
+// Connected moves the count of an attempt from first, the first host returned by Order, to connected, the host
+// connected to or nil if the attempt failed.
+func (b *Balancer) Connected(first, connected *FallbackConfig) {
+	b.mutex.Lock()
+	b.stat(first).conns--
+	if connected != nil {
+		b.stat(connected).conns++
+	}
+	b.mutex.Unlock()
+}
+
+// Disconnected counts a closed connection to the host of fallbackConfig.
+func (b *Balancer) Disconnected(fallbackConfig *FallbackConfig) {
+	b.mutex.Lock()
+	b.stat(fallbackConfig).conns--
+	b.mutex.Unlock()
+}
+
+// SetLatency records the measured latency of the host of fallbackConfig, negative if it is unreachable.
+func (b *Balancer) SetLatency(fallbackConfig *FallbackConfig, latency time.Duration) {
+	b.mutex.Lock()
+	b.stat(fallbackConfig).latency = latency
+	b.mutex.Unlock()
+}
+
+// Connections returns the number of connections to the host of fallbackConfig.
+func (b *Balancer) Connections(fallbackConfig *FallbackConfig) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.stat(fallbackConfig).conns
+}
+
+func (b *Balancer) stat(fallbackConfig *FallbackConfig) *hostStat {
+	_, address := NetworkAddress(fallbackConfig.Host, fallbackConfig.Port)
+	stat, ok := b.hosts[address]
+	if !ok {
+		stat = &hostStat{}
+		b.hosts[address] = stat
+	}
+	return stat
+}
+
+// groupFallbacks groups consecutive fallbacks of the same host.
+func groupFallbacks(fallbacks []*FallbackConfig) [][]*FallbackConfig {
+	var groups [][]*FallbackConfig
+	for _, fallback := range fallbacks {
+		if n := len(groups); n > 0 && groups[n-1][0].Host == fallback.Host && groups[n-1][0].Port == fallback.Port {
+			groups[n-1] = append(groups[n-1], fallback)
+			continue
+		}
+		groups = append(groups, []*FallbackConfig{fallback})
+	}
+	return groups
+}
+
+// HostConfigs returns a config for every host of c with the fallbacks of the host only, e.g. to measure the latency
+// of the hosts. ValidateConnect and AfterConnect are not set.
+func (c *Config) HostConfigs() []*Config {
+	fallbacks := append([]*FallbackConfig{{Host: c.Host, Port: c.Port, TLSConfig: c.TLSConfig}}, c.Fallbacks...)
+	return hostConfigs(c, fallbacks)
+}
+
+func hostConfigs(c *Config, fallbacks []*FallbackConfig) []*Config {
+	var configs []*Config
+	for _, group := range groupFallbacks(fallbacks) {
+		config := c.Copy()
+		config.Host = group[0].Host
+		config.Port = group[0].Port
+		config.TLSConfig = group[0].TLSConfig
+		config.Fallbacks = copyFallbacks(group[1:])
+		config.Replicas = nil
+		config.ValidateConnect = nil
+		config.AfterConnect = nil
+		configs = append(configs, config)
+	}
+	return configs
+}
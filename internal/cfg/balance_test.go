@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func testFallbacks() []*FallbackConfig {
+	return []*FallbackConfig{
+		{Host: "a", Port: 5432, TLSConfig: &tls.Config{}},
+		{Host: "a", Port: 5432},
+		{Host: "b", Port: 5432},
+		{Host: "c", Port: 5432},
+	}
+}
+
+func hosts(fallbacks []*FallbackConfig) string {
+	var s string
+	for _, fallback := range fallbacks {
+		s += fallback.Host
+	}
+	return s
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b := NewBalancer(LoadBalanceRoundRobin)
+	for _, expected := range []string{"aabc", "bcaa", "caab", "aabc"} {
+		ordered := b.Order(testFallbacks())
+		if hosts(ordered) != expected {
+			t.Errorf("expected %s, got %s", expected, hosts(ordered))
+		}
+		if ordered[0].Host == "a" && ordered[0].TLSConfig == nil {
+			t.Error("the fallbacks of a host are reordered")
+		}
+	}
+}
+
+func TestBalancerLeastConnections(t *testing.T) {
+	b := NewBalancer(LoadBalanceLeastConnections)
+	fallbacks := testFallbacks()
+
+	first := b.Order(fallbacks)[0]
+	if first.Host != "a" {
+		t.Fatalf("expected a, got %s", first.Host)
+	}
+	if first := b.Order(fallbacks)[0]; first.Host != "b" {
+		t.Errorf("a pending attempt is not counted, got %s", first.Host)
+	}
+
+	// the attempt to a ended on c
+	b.Connected(first, fallbacks[3])
+	if n := b.Connections(fallbacks[0]); n != 0 {
+		t.Errorf("expected 0 connections to a, got %d", n)
+	}
+	if first := b.Order(fallbacks)[0]; first.Host != "a" {
+		t.Errorf("expected a, got %s", first.Host)
+	}
+}
+
+func TestBalancerLatency(t *testing.T) {
+	b := NewBalancer(LoadBalanceLatency)
+	fallbacks := testFallbacks()
+	b.SetLatency(fallbacks[0], -1)
+	b.SetLatency(fallbacks[2], time.Millisecond)
+	b.SetLatency(fallbacks[3], time.Second)
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		ordered := b.Order(fallbacks)
+		counts[ordered[0].Host]++
+		if last := ordered[len(ordered)-1]; last.Host != "a" {
+			t.Fatalf("the unreachable host is not last: %s", hosts(ordered))
+		}
+	}
+	if counts["b"] < 900 {
+		t.Errorf("expected mostly b, got %v", counts)
+	}
+}
@@ -26,8 +26,30 @@ import (
 	"github.com/jackc/pgservicefile"
 
 	"pap/internal/pgproto"
+	"pap/internal/pgtype"
 )
 
+// sslnegotiation values. With SSLNegotiationDirect the TLS handshake starts without the SSLRequest round trip, which
+// is supported by PostgreSQL 17 and TLS-terminating proxies.
+const (
+	SSLNegotiationPostgres = "postgres"
+	SSLNegotiationDirect   = "direct"
+)
+
+// channel_binding values. ChannelBindingRequire fails the connection if the server does not authenticate with
+// SCRAM-SHA-256-PLUS bound to the TLS connection.
+const (
+	ChannelBindingDisable = "disable"
+	ChannelBindingPrefer  = "prefer"
+	ChannelBindingRequire = "require"
+)
+
+// ALPNProtocol is the ALPN protocol name of PostgreSQL, required by the server for direct TLS connections.
+const ALPNProtocol = "postgresql"
+
+// PasswordProvider returns the password of user for a connection to host, see Config.PasswordProvider.
+type PasswordProvider func(ctx context.Context, host, user string) (string, error)
+
 // DialFunc is a function that can be used to connect to a PostgreSQL server.
 type DialFunc func(network, addr string) (net.Conn, error)
 
@@ -37,9 +59,11 @@ type BuildFrontendFunc func(r io.Reader, w io.Writer) *pgproto.Frontend
 // LookupFunc is a function that can be used to lookup IPs addrs from host.
 type LookupFunc func(ctx context.Context, host string) (addrs []string, err error)
 
-// TODO after + validate connect
-//type AfterConnectFunc func(ctx context.Context, pgconn *PgConn) error
-//type ValidateConnectFunc func(ctx context.Context, pgconn *PgConn) error
+// NoticeHandler is a function that can handle notices received from the PostgreSQL server. Notices can be received at
+// any time, usually during handling of a query response. number is the number of the receiving connection and pid
+// its backend pid. The handler is called on the connection goroutine, so it must not block. Be aware that this is
+// distinct from LISTEN/NOTIFY notification.
+type NoticeHandler func(number int, pid uint32, notice *Notice)
 
 // Config is the settings used to establish a connection to a PostgreSQL server. It must be created by ParseConfig. A
 // manually initialized Config will cause ConnectConfig to panic.
@@ -49,29 +73,94 @@ type Config struct {
 	Database       string
 	User           string
 	Password       string
-	TLSConfig      *tls.Config // nil disables TLS
+	TLSConfig      *tls.Config  // nil disables TLS
+	SSLMode        string       // the sslmode the TLS configs were built for, to explain TLS errors
+	SSLNegotiation string       // "postgres" sends SSLRequest first, "direct" starts the TLS handshake immediately
+	ChannelBinding string       // channel_binding of SCRAM-SHA-256-PLUS: "disable", "prefer" or "require"
+	RequireAuth    *AuthMethods // the authentication methods the server may request, nil allows all
 	ConnectTimeout time.Duration
-	DialFunc       DialFunc   // e.g. net.Dialer.DialContext
-	LookupFunc     LookupFunc // e.g. net.Resolver.LookupHost
-	BuildFrontend  BuildFrontendFunc
-	RuntimeParams  map[string]string // Run-time parameters to set on connection as session default values (e.g. search_path or application_name)
+
+	// TCP keepalive settings of libpq: keepalives, keepalives_idle, keepalives_interval and keepalives_count.
+	// KeepAlivesInterval and KeepAlivesCount are only applied on Linux. Zero values use the system defaults.
+	KeepAlives         bool
+	KeepAlivesIdle     time.Duration
+	KeepAlivesInterval time.Duration
+	KeepAlivesCount    int
+	TCPUserTimeout     time.Duration // tcp_user_timeout, only applied on Linux
+
+	// ReadTimeout and WriteTimeout fail a connection that makes no progress reading a response or writing a message
+	// for longer, e.g. after a silent network partition. A failed pool connection is recycled. ReadTimeout must be
+	// longer than the slowest query. Zero disables them.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	DialFunc      DialFunc   // e.g. net.Dialer.DialContext
+	LookupFunc    LookupFunc // e.g. net.Resolver.LookupHost
+	BuildFrontend BuildFrontendFunc
+	RuntimeParams map[string]string // Run-time parameters to set on connection as session default values (e.g. search_path or application_name)
+
+	// ConnInfo is used to encode query parameters and to scan results. Extension and custom types are registered on
+	// it before start. nil means pgtype.NewConnInfo(). Copy does not copy ConnInfo.
+	ConnInfo *pgtype.ConnInfo
 
 	Fallbacks []*FallbackConfig
 
+	// FailbackInterval is the interval in which a pool connection to a fallback host tries to move back to a more
+	// preferred host, e.g. to the first host after it recovered. Zero disables it.
+	FailbackInterval time.Duration
+
+	// Replicas are the hosts of the replica pool used for read-only queries, from replica_hosts, e.g.
+	// replica_hosts=r1,r2:5433. Like Fallbacks a host has an entry for every TLS setting to try.
+	Replicas []*FallbackConfig
+
+	// MaxReplicaLag ejects a replica from the replica pool while its replay lags further behind the primary. Zero
+	// disables the lag check, unreachable replicas are ejected anyway.
+	MaxReplicaLag time.Duration
+
+	// ReplicaCheckInterval is the interval of the health checks of the replicas.
+	ReplicaCheckInterval time.Duration
+
+	// Balancer spreads the connections over the hosts by the load_balance_hosts policy, nil tries the hosts in order.
+	// It is shared by the copies of the config.
+	Balancer *Balancer
+
+	// LatencyCheckInterval is the interval of the latency measurement of load_balance_hosts=latency.
+	LatencyCheckInterval time.Duration
+
+	// MaxConnLifetime retires a pool connection once it is idle after it was connected for this duration plus a random
+	// part of MaxConnLifetimeJitter, so that the connections are not replaced all at once. MaxConnIdleTime retires a
+	// pool connection that was not used for this duration. A retired connection reconnects in the background. Zero
+	// disables them.
+	MaxConnLifetime       time.Duration
+	MaxConnLifetimeJitter time.Duration
+	MaxConnIdleTime       time.Duration
+
+	// MaxQueueLength limits the queries waiting for a pool connection, further queries fail with ErrPoolExhausted
+	// instead of waiting. AcquireTimeout limits the time a query waits for a pool connection. Zero disables them.
+	MaxQueueLength int
+	AcquireTimeout time.Duration
+
 	// ValidateConnect is called during a connection attempt after a successful authentication with the PostgreSQL server.
 	// It can be used to validate that the server is acceptable. If this returns an error the connection is closed and the next
 	// fallback config is tried. This allows implementing high availability behavior such as libpq does with target_session_attrs.
-	// TODO after + validate connect
-	//ValidateConnect ValidateConnectFunc
+	ValidateConnect ValidateConnectFunc
+
+	// PasswordProvider returns the password of User for a connection to host. It is called for every new connection
+	// the server requests a password for, instead of using Password, so that rotated secrets and short-lived tokens
+	// take effect without a restart.
+	PasswordProvider PasswordProvider
 
-	// AfterConnect is called after ValidateConnect. It can be used to set up the connection (e.g. Set session variables
-	// or prepare statements). If this returns an error the connection attempt fails.
-	// TODO after + validate connect
-	//AfterConnect AfterConnectFunc
+	// OAuthTokenProvider returns the bearer token if the server requests OAUTHBEARER authentication. Without it the
+	// connection fails if the server offers no other SASL mechanism.
+	OAuthTokenProvider OAuthTokenProvider
+
+	// AfterConnect is called after ValidateConnect on every new connection, before it is used. It can be used to set
+	// up the connection (e.g. Set session variables or prepare statements), see Session for its limits. If this
+	// returns an error the connection is closed and the connection attempt fails, pool connections retry to connect.
+	AfterConnect AfterConnectFunc
 
 	// OnNotice is a callback function called when a notice response is received.
-	// TODO notice
-	//OnNotice NoticeHandler
+	OnNotice NoticeHandler
 
 	// OnNotification is a callback function called when a notification from the LISTEN/NOTIFY system is received.
 	// TODO notice
@@ -95,20 +184,28 @@ func (c *Config) Copy() *Config {
 			newConf.RuntimeParams[k] = v
 		}
 	}
+	if newConf.Replicas != nil {
+		newConf.Replicas = copyFallbacks(c.Replicas)
+	}
 	if newConf.Fallbacks != nil {
-		newConf.Fallbacks = make([]*FallbackConfig, len(c.Fallbacks))
-		for i, fallback := range c.Fallbacks {
-			newFallback := new(FallbackConfig)
-			*newFallback = *fallback
-			if newFallback.TLSConfig != nil {
-				newFallback.TLSConfig = fallback.TLSConfig.Clone()
-			}
-			newConf.Fallbacks[i] = newFallback
-		}
+		newConf.Fallbacks = copyFallbacks(c.Fallbacks)
 	}
 	return newConf
 }
 
+func copyFallbacks(fallbacks []*FallbackConfig) []*FallbackConfig {
+	newFallbacks := make([]*FallbackConfig, len(fallbacks))
+	for i, fallback := range fallbacks {
+		newFallback := new(FallbackConfig)
+		*newFallback = *fallback
+		if newFallback.TLSConfig != nil {
+			newFallback.TLSConfig = fallback.TLSConfig.Clone()
+		}
+		newFallbacks[i] = newFallback
+	}
+	return newFallbacks
+}
+
 // FallbackConfig is additional settings to attempt a connection with when the primary Config fails to establish a
 // network connection. It is used for TLS fallback such as sslmode=prefer and high availability (HA) connections.
 type FallbackConfig struct {
@@ -117,6 +214,38 @@ type FallbackConfig struct {
 	TLSConfig *tls.Config // nil disables TLS
 }
 
+// tlsFallbacks returns the fallback configs of host, one for every TLS config to try.
+func tlsFallbacks(settings map[string]string, host string, port uint16) ([]*FallbackConfig, error) {
+	var tlsConfigs []*tls.Config
+
+	// Ignore TLS settings if Unix domain socket like libpq
+	if network, _ := NetworkAddress(host, port); network == "unix" {
+		tlsConfigs = append(tlsConfigs, nil)
+	} else {
+		var err error
+		tlsConfigs, err = configTLS(settings, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fallbacks := make([]*FallbackConfig, 0, len(tlsConfigs))
+	for _, tlsConfig := range tlsConfigs {
+		fallbacks = append(fallbacks, &FallbackConfig{
+			Host:      host,
+			Port:      port,
+			TLSConfig: tlsConfig,
+		})
+	}
+	return fallbacks, nil
+}
+
+// ReplicaConfigs returns a config for every host of Replicas. Its fallbacks are the other TLS settings of the host,
+// and target_session_attrs does not apply. AfterConnect is not set, the configs are used by the health checks.
+func (c *Config) ReplicaConfigs() []*Config {
+	return hostConfigs(c, c.Replicas)
+}
+
 // NetworkAddress converts a PostgreSQL host and port into network and address suitable for use with
 // net.Dial.
 func NetworkAddress(host string, port uint16) (network, address string) {
@@ -179,30 +308,92 @@ func (c *Config) ParseConfig(connString string) error {
 			return &parseConfigError{connString: connString, msg: "invalid connect_timeout", err: err}
 		}
 		c.ConnectTimeout = connectTimeout
-		c.DialFunc = makeConnectTimeoutDialFunc(connectTimeout)
-	} else {
-		defaultDialer := makeDefaultDialer()
-		c.DialFunc = defaultDialer.Dial
 	}
 
+	c.KeepAlives = settings["keepalives"] != "0"
+	durationSettings := []struct {
+		name string
+		unit time.Duration
+		dst  *time.Duration
+	}{
+		{"keepalives_idle", time.Second, &c.KeepAlivesIdle},
+		{"keepalives_interval", time.Second, &c.KeepAlivesInterval},
+		{"tcp_user_timeout", time.Millisecond, &c.TCPUserTimeout},
+		{"read_timeout", time.Second, &c.ReadTimeout},
+		{"write_timeout", time.Second, &c.WriteTimeout},
+		{"failback_interval", time.Second, &c.FailbackInterval},
+		{"replica_max_lag", time.Second, &c.MaxReplicaLag},
+		{"replica_check_interval", time.Second, &c.ReplicaCheckInterval},
+		{"latency_check_interval", time.Second, &c.LatencyCheckInterval},
+		{"pool_max_conn_lifetime", time.Second, &c.MaxConnLifetime},
+		{"pool_max_conn_lifetime_jitter", time.Second, &c.MaxConnLifetimeJitter},
+		{"pool_max_conn_idle_time", time.Second, &c.MaxConnIdleTime},
+		{"pool_acquire_timeout", time.Second, &c.AcquireTimeout},
+	}
+	for _, ds := range durationSettings {
+		if s, present := settings[ds.name]; present {
+			*ds.dst, err = parseDurationSetting(s, ds.unit)
+			if err != nil {
+				return &parseConfigError{connString: connString, msg: "invalid " + ds.name, err: err}
+			}
+		}
+	}
+	if s, present := settings["keepalives_count"]; present {
+		c.KeepAlivesCount, err = strconv.Atoi(s)
+		if err != nil || c.KeepAlivesCount < 0 {
+			return &parseConfigError{connString: connString, msg: "invalid keepalives_count", err: err}
+		}
+	}
+
+	if s, present := settings["pool_max_queue_length"]; present {
+		c.MaxQueueLength, err = strconv.Atoi(s)
+		if err != nil || c.MaxQueueLength < 0 {
+			return &parseConfigError{connString: connString, msg: "invalid pool_max_queue_length", err: err}
+		}
+	}
+
+	c.DialFunc = makeDialFunc(c)
+
 	c.LookupFunc = makeDefaultResolver().LookupHost
 
 	notRuntimeParams := map[string]struct{}{
-		"host":                 {},
-		"port":                 {},
-		"database":             {},
-		"user":                 {},
-		"password":             {},
-		"passfile":             {},
-		"connect_timeout":      {},
-		"sslmode":              {},
-		"sslkey":               {},
-		"sslcert":              {},
-		"sslrootcert":          {},
-		"target_session_attrs": {},
-		"min_read_buffer_size": {},
-		"service":              {},
-		"servicefile":          {},
+		"host":                   {},
+		"port":                   {},
+		"database":               {},
+		"user":                   {},
+		"password":               {},
+		"passfile":               {},
+		"connect_timeout":        {},
+		"keepalives":             {},
+		"keepalives_idle":        {},
+		"keepalives_interval":    {},
+		"keepalives_count":       {},
+		"tcp_user_timeout":       {},
+		"read_timeout":           {},
+		"write_timeout":          {},
+		"failback_interval":      {},
+		"replica_hosts":          {},
+		"replica_max_lag":        {},
+		"replica_check_interval": {},
+		"load_balance_hosts":     {},
+		"latency_check_interval": {},
+		"sslmode":                {},
+		"sslnegotiation":         {},
+		"channel_binding":        {},
+		"require_auth":           {},
+		"sslkey":                 {},
+		"sslcert":                {},
+		"sslrootcert":            {},
+		"target_session_attrs":   {},
+		"min_read_buffer_size":   {},
+		"service":                {},
+		"servicefile":            {},
+
+		"pool_max_conn_lifetime":        {},
+		"pool_max_conn_lifetime_jitter": {},
+		"pool_max_conn_idle_time":       {},
+		"pool_max_queue_length":         {},
+		"pool_acquire_timeout":          {},
 	}
 
 	for k, v := range settings {
@@ -230,26 +421,61 @@ func (c *Config) ParseConfig(connString string) error {
 			return &parseConfigError{connString: connString, msg: "invalid port", err: err}
 		}
 
-		var tlsConfigs []*tls.Config
+		hostFallbacks, err := tlsFallbacks(settings, host, port)
+		if err != nil {
+			return &parseConfigError{connString: connString, msg: "failed to configure TLS", err: err}
+		}
+		fallbacks = append(fallbacks, hostFallbacks...)
+	}
 
-		// Ignore TLS settings if Unix domain socket like libpq
-		if network, _ := NetworkAddress(host, port); network == "unix" {
-			tlsConfigs = append(tlsConfigs, nil)
-		} else {
-			var err error
-			tlsConfigs, err = configTLS(settings, host)
+	c.Replicas = nil
+	if replicaHosts := settings["replica_hosts"]; replicaHosts != "" {
+		for _, replica := range strings.Split(replicaHosts, ",") {
+			host, portStr := replica, ports[0]
+			if !strings.HasPrefix(replica, "/") && !isIPOnly(replica) {
+				host, portStr, err = net.SplitHostPort(replica)
+				if err != nil {
+					return &parseConfigError{connString: connString, msg: "invalid replica_hosts", err: err}
+				}
+			}
+			host = strings.Trim(host, "[]")
+
+			port, err := parsePort(portStr)
+			if err != nil {
+				return &parseConfigError{connString: connString, msg: "invalid replica port", err: err}
+			}
+
+			hostFallbacks, err := tlsFallbacks(settings, host, port)
 			if err != nil {
 				return &parseConfigError{connString: connString, msg: "failed to configure TLS", err: err}
 			}
+			c.Replicas = append(c.Replicas, hostFallbacks...)
 		}
+	}
 
-		for _, tlsConfig := range tlsConfigs {
-			fallbacks = append(fallbacks, &FallbackConfig{
-				Host:      host,
-				Port:      port,
-				TLSConfig: tlsConfig,
-			})
+	c.SSLMode = settings["sslmode"]
+	if c.SSLMode == "" {
+		c.SSLMode = "prefer"
+	}
+	c.SSLNegotiation = settings["sslnegotiation"]
+	if c.SSLNegotiation == "" {
+		c.SSLNegotiation = SSLNegotiationPostgres
+	}
+
+	switch c.ChannelBinding = settings["channel_binding"]; c.ChannelBinding {
+	case "":
+		c.ChannelBinding = ChannelBindingPrefer
+	case ChannelBindingDisable, ChannelBindingPrefer:
+	case ChannelBindingRequire:
+		if c.SSLMode == "disable" {
+			return &parseConfigError{connString: connString, msg: "channel_binding=require is not allowed with sslmode=disable"}
 		}
+	default:
+		return &parseConfigError{connString: connString, msg: fmt.Sprintf("unknown channel_binding value: %v", c.ChannelBinding)}
+	}
+
+	if c.RequireAuth, err = ParseRequireAuth(settings["require_auth"]); err != nil {
+		return &parseConfigError{connString: connString, msg: "invalid require_auth", err: err}
 	}
 
 	c.Host = fallbacks[0].Host
@@ -269,12 +495,18 @@ func (c *Config) ParseConfig(connString string) error {
 		}
 	}
 
-	if settings["target_session_attrs"] == "read-write" {
-		// TODO validate connect
-		//config.ValidateConnect = ValidateConnectTargetSessionAttrsReadWrite
-	} else if settings["target_session_attrs"] != "any" {
+	switch policy := settings["load_balance_hosts"]; policy {
+	case LoadBalanceDisable, LoadBalanceRandom, LoadBalanceRoundRobin, LoadBalanceLeastConnections, LoadBalanceLatency:
+		c.Balancer = NewBalancer(policy)
+	default:
+		return &parseConfigError{connString: connString, msg: fmt.Sprintf("unknown load_balance_hosts value: %v", policy)}
+	}
+
+	validateConnect, ok := targetSessionAttrs[settings["target_session_attrs"]]
+	if !ok {
 		return &parseConfigError{connString: connString, msg: fmt.Sprintf("unknown target_session_attrs value: %v", settings["target_session_attrs"])}
 	}
+	c.ValidateConnect = validateConnect
 
 	return nil
 }
@@ -304,10 +536,14 @@ func parseEnvSettings() map[string]string {
 		"PGAPPNAME":            "application_name",
 		"PGCONNECT_TIMEOUT":    "connect_timeout",
 		"PGSSLMODE":            "sslmode",
+		"PGSSLNEGOTIATION":     "sslnegotiation",
+		"PGCHANNELBINDING":     "channel_binding",
+		"PGREQUIREAUTH":        "require_auth",
 		"PGSSLKEY":             "sslkey",
 		"PGSSLCERT":            "sslcert",
 		"PGSSLROOTCERT":        "sslrootcert",
 		"PGTARGETSESSIONATTRS": "target_session_attrs",
+		"PGLOADBALANCEHOSTS":   "load_balance_hosts",
 		"PGSERVICE":            "service",
 		"PGSERVICEFILE":        "servicefile",
 	}
@@ -499,12 +735,24 @@ func configTLS(settings map[string]string, thisHost string) ([]*tls.Config, erro
 	sslrootcert := settings["sslrootcert"]
 	sslcert := settings["sslcert"]
 	sslkey := settings["sslkey"]
+	sslnegotiation := settings["sslnegotiation"]
 
 	// Match libpq default behavior
 	if sslmode == "" {
 		sslmode = "prefer"
 	}
 
+	switch sslnegotiation {
+	case "", SSLNegotiationPostgres:
+	case SSLNegotiationDirect:
+		// like libpq, direct negotiation has no plaintext fallback, so it requires TLS
+		if sslmode == "disable" || sslmode == "allow" || sslmode == "prefer" {
+			return nil, fmt.Errorf("sslnegotiation=direct is not allowed with sslmode=%s", sslmode)
+		}
+	default:
+		return nil, errors.New("sslnegotiation is invalid")
+	}
+
 	tlsConfig := &tls.Config{}
 
 	switch sslmode {
@@ -564,6 +812,11 @@ func configTLS(settings map[string]string, thisHost string) ([]*tls.Config, erro
 		return nil, errors.New("sslmode is invalid")
 	}
 
+	if sslnegotiation == SSLNegotiationDirect {
+		// the server requires ALPN to tell PostgreSQL from other protocols on a direct TLS connection
+		tlsConfig.NextProtos = []string{ALPNProtocol}
+	}
+
 	if sslrootcert != "" {
 		caCertPool := x509.NewCertPool()
 
@@ -648,27 +901,56 @@ func parseConnectTimeoutSetting(s string) (time.Duration, error) {
 	return time.Duration(timeout) * time.Second, nil
 }
 
-func makeConnectTimeoutDialFunc(timeout time.Duration) DialFunc {
+// parseDurationSetting parses s as an integer number of unit, like libpq, or as a Go duration, e.g. 1m30s.
+func parseDurationSetting(s string, unit time.Duration) (time.Duration, error) {
+	var d time.Duration
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		d = time.Duration(n) * unit
+	} else {
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if d < 0 {
+		return 0, errors.New("negative duration")
+	}
+	return d, nil
+}
+
+// makeDialFunc returns a DialFunc applying the connect timeout and the TCP keepalive settings of c.
+func makeDialFunc(c *Config) DialFunc {
 	d := makeDefaultDialer()
-	d.Timeout = timeout
-	return d.Dial
+	d.Timeout = c.ConnectTimeout
+	if !c.KeepAlives {
+		d.KeepAlive = -1
+	} else if c.KeepAlivesIdle > 0 {
+		d.KeepAlive = c.KeepAlivesIdle
+	}
+
+	interval, count, userTimeout := c.KeepAlivesInterval, c.KeepAlivesCount, c.TCPUserTimeout
+	if !c.KeepAlives {
+		interval, count = 0, 0
+	}
+	if interval == 0 && count == 0 && userTimeout == 0 {
+		return d.Dial
+	}
+
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := d.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			if err := setKeepAliveOptions(tcpConn, interval, count, userTimeout); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
 }
 
-//// ValidateConnectTargetSessionAttrsReadWrite is an ValidateConnectFunc that implements libpq compatible
-//// target_session_attrs=read-write.
-// TODO validate connect
-//func ValidateConnectTargetSessionAttrsReadWrite(ctx context.Context, pgConn *PgConn) error {
-//	result := pgConn.ExecParams(ctx, "show transaction_read_only", nil, nil, nil, nil).Read()
-//	if result.Err != nil {
-//		return result.Err
-//	}
-//
-//	if string(result.Rows[0][0]) == "on" {
-//		return errors.New("read only connection")
-//	}
-//
-//	return nil
-//}
 // TODO fallbacks
 //func expandWithIPs(ctx context.Context, lookupFn LookupFunc, fallbacks []*FallbackConfig) ([]*FallbackConfig, error) {
 //	var configs []*FallbackConfig
@@ -40,6 +40,11 @@ func defaultSettings() map[string]string {
 
 	settings["target_session_attrs"] = "any"
 
+	settings["failback_interval"] = "30"
+	settings["replica_check_interval"] = "5"
+	settings["load_balance_hosts"] = "disable"
+	settings["latency_check_interval"] = "5"
+
 	settings["min_read_buffer_size"] = "8192"
 
 	return settings
@@ -0,0 +1,44 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT, which is missing in syscall.
+const tcpUserTimeout = 0x12
+
+// setKeepAliveOptions sets the keepalive interval and count and the user timeout of conn, zero values are not set.
+func setKeepAliveOptions(conn *net.TCPConn, interval time.Duration, count int, userTimeout time.Duration) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if interval > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds(interval))
+		}
+		if sockErr == nil && count > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+		}
+		if sockErr == nil && userTimeout > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, int(userTimeout/time.Millisecond))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
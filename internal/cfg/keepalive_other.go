@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"net"
+	"time"
+)
+
+// setKeepAliveOptions is a no-op, the keepalive interval and count and the user timeout are only set on Linux.
+func setKeepAliveOptions(conn *net.TCPConn, interval time.Duration, count int, userTimeout time.Duration) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+// Notice represents a notice response message reported by the PostgreSQL server. It has the same fields as an error
+// response, see http://www.postgresql.org/docs/11/static/protocol-error-fields.html for detailed field description.
+type Notice struct {
+	Severity         string
+	Code             string
+	Message          string
+	Detail           string
+	Hint             string
+	Position         int32
+	InternalPosition int32
+	InternalQuery    string
+	Where            string
+	SchemaName       string
+	TableName        string
+	ColumnName       string
+	DataTypeName     string
+	ConstraintName   string
+	File             string
+	Line             int32
+	Routine          string
+}
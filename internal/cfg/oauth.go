@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"context"
+	"fmt"
+)
+
+// OAuthTokenRequest describes the bearer token needed for a connection attempt.
+type OAuthTokenRequest struct {
+	Host string
+	User string
+
+	// Failure is the rejection of the previous token by the server, nil on the first attempt of a connection. The
+	// provider should not return the same token again, e.g. refresh a cached token.
+	Failure *OAuthError
+}
+
+// OAuthTokenProvider returns the bearer token for OAUTHBEARER authentication, see Config.OAuthTokenProvider. It is
+// called for every new connection and, after the server rejected a token, once more for a retry.
+type OAuthTokenProvider func(ctx context.Context, req OAuthTokenRequest) (string, error)
+
+// OAuthError is the rejection of a bearer token by the server. The fields are from the error status of RFC 7628,
+// Scope and OpenIDConfiguration tell the provider how to obtain an acceptable token.
+type OAuthError struct {
+	Status              string
+	Scope               string
+	OpenIDConfiguration string
+
+	Err error // the error response of the server that ended the attempt
+}
+
+func (e *OAuthError) Error() string {
+	msg := fmt.Sprintf("server rejected OAuth bearer token: %s", e.Status)
+	if e.Err != nil {
+		msg += " (" + e.Err.Error() + ")"
+	}
+	return msg
+}
+
+func (e *OAuthError) Unwrap() error {
+	return e.Err
+}
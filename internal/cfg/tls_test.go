@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"testing"
+)
+
+func TestParseConfigSSLNegotiation(t *testing.T) {
+	var c Config
+	if err := c.ParseConfig("host=localhost sslmode=require sslnegotiation=direct"); err != nil {
+		t.Fatal(err)
+	}
+	if c.SSLNegotiation != SSLNegotiationDirect {
+		t.Errorf("SSLNegotiation = %q", c.SSLNegotiation)
+	}
+	if c.TLSConfig == nil || len(c.TLSConfig.NextProtos) != 1 || c.TLSConfig.NextProtos[0] != ALPNProtocol {
+		t.Errorf("ALPN is not configured: %v", c.TLSConfig)
+	}
+	if len(c.Fallbacks) != 0 {
+		t.Errorf("unexpected fallbacks: %v", c.Fallbacks)
+	}
+
+	if err := c.ParseConfig("host=localhost sslmode=require"); err != nil {
+		t.Fatal(err)
+	}
+	if c.SSLNegotiation != SSLNegotiationPostgres {
+		t.Errorf("default SSLNegotiation = %q", c.SSLNegotiation)
+	}
+
+	for _, connString := range []string{
+		"host=localhost sslmode=prefer sslnegotiation=direct",
+		"host=localhost sslmode=disable sslnegotiation=direct",
+		"host=localhost sslmode=require sslnegotiation=unknown",
+	} {
+		if err := c.ParseConfig(connString); err == nil {
+			t.Errorf("expected error for %q", connString)
+		}
+	}
+}
+
+func TestParseConfigChannelBinding(t *testing.T) {
+	var c Config
+	if err := c.ParseConfig("host=localhost"); err != nil {
+		t.Fatal(err)
+	}
+	if c.ChannelBinding != ChannelBindingPrefer {
+		t.Errorf("default ChannelBinding = %q", c.ChannelBinding)
+	}
+
+	if err := c.ParseConfig("host=localhost sslmode=require channel_binding=require"); err != nil {
+		t.Fatal(err)
+	}
+	if c.ChannelBinding != ChannelBindingRequire {
+		t.Errorf("ChannelBinding = %q", c.ChannelBinding)
+	}
+	if _, ok := c.RuntimeParams["channel_binding"]; ok {
+		t.Error("channel_binding is sent as a run-time parameter")
+	}
+
+	for _, connString := range []string{
+		"host=localhost sslmode=disable channel_binding=require",
+		"host=localhost channel_binding=unknown",
+	} {
+		if err := c.ParseConfig(connString); err == nil {
+			t.Errorf("expected error for %q", connString)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Session is a connection during a connection attempt, it is passed to ValidateConnect and AfterConnect.
+//
+// A Session only executes simple queries. Statements prepared by PREPARE belong to the connection, they are used by
+// EXECUTE and not by the queries of the pool, which prepare their statements themselves. As AfterConnect runs on
+// every new connection, including reconnects, they are prepared again. Types are registered on Config.ConnInfo
+// before the pool is started, it is shared by the connections and not safe for concurrent use.
+type Session interface {
+	// Exec executes sql with the simple query protocol and returns the rows of the last statement in the text
+	// format, a value is nil for NULL. The deadline of ctx is applied to the connection.
+	Exec(ctx context.Context, sql string) ([][][]byte, error)
+
+	// ParameterStatus returns the value of a parameter reported by the server, e.g. server_version or
+	// in_hot_standby, or "" if it was not reported.
+	ParameterStatus(key string) string
+
+	// PID returns the backend pid.
+	PID() uint32
+}
+
+// ValidateConnectFunc validates the server of a connection attempt after a successful authentication, see
+// Config.ValidateConnect.
+type ValidateConnectFunc func(ctx context.Context, s Session) error
+
+// AfterConnectFunc sets up a new connection before it is used, see Config.AfterConnect.
+type AfterConnectFunc func(ctx context.Context, s Session) error
+
+// NotPreferredError is returned by ValidateConnect if the server is acceptable but not preferred. The connection
+// attempt continues with the next host, and falls back to the first not preferred host if no host is accepted.
+type NotPreferredError struct {
+	err error
+}
+
+func (e *NotPreferredError) Error() string {
+	return e.err.Error()
+}
+
+func (e *NotPreferredError) Unwrap() error {
+	return e.err
+}
+
+// targetSessionAttrs maps the target_session_attrs values to their ValidateConnectFunc, nil for any.
+var targetSessionAttrs = map[string]ValidateConnectFunc{
+	"any":            nil,
+	"read-write":     ValidateConnectTargetSessionAttrsReadWrite,
+	"read-only":      ValidateConnectTargetSessionAttrsReadOnly,
+	"primary":        ValidateConnectTargetSessionAttrsPrimary,
+	"standby":        ValidateConnectTargetSessionAttrsStandby,
+	"prefer-standby": ValidateConnectTargetSessionAttrsPreferStandby,
+}
+
+// ValidateConnectTargetSessionAttrsReadWrite implements libpq compatible target_session_attrs=read-write.
+func ValidateConnectTargetSessionAttrsReadWrite(ctx context.Context, s Session) error {
+	readOnly, err := isReadOnly(ctx, s)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		return errors.New("read only connection")
+	}
+	return nil
+}
+
+// ValidateConnectTargetSessionAttrsReadOnly implements libpq compatible target_session_attrs=read-only.
+func ValidateConnectTargetSessionAttrsReadOnly(ctx context.Context, s Session) error {
+	readOnly, err := isReadOnly(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !readOnly {
+		return errors.New("connection is not read only")
+	}
+	return nil
+}
+
+// ValidateConnectTargetSessionAttrsPrimary implements libpq compatible target_session_attrs=primary.
+func ValidateConnectTargetSessionAttrsPrimary(ctx context.Context, s Session) error {
+	standby, err := isStandby(ctx, s)
+	if err != nil {
+		return err
+	}
+	if standby {
+		return errors.New("server is in standby mode")
+	}
+	return nil
+}
+
+// ValidateConnectTargetSessionAttrsStandby implements libpq compatible target_session_attrs=standby.
+func ValidateConnectTargetSessionAttrsStandby(ctx context.Context, s Session) error {
+	standby, err := isStandby(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !standby {
+		return errors.New("server is not in standby mode")
+	}
+	return nil
+}
+
+// ValidateConnectTargetSessionAttrsPreferStandby implements libpq compatible target_session_attrs=prefer-standby.
+// A primary is used only if no standby is reachable.
+func ValidateConnectTargetSessionAttrsPreferStandby(ctx context.Context, s Session) error {
+	standby, err := isStandby(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !standby {
+		return &NotPreferredError{err: errors.New("server is not in standby mode")}
+	}
+	return nil
+}
+
+// isReadOnly uses the in_hot_standby and default_transaction_read_only parameters reported by PostgreSQL 14 and
+// later, or asks the server.
+func isReadOnly(ctx context.Context, s Session) (bool, error) {
+	inHotStandby := s.ParameterStatus("in_hot_standby")
+	defaultReadOnly := s.ParameterStatus("default_transaction_read_only")
+	if inHotStandby != "" && defaultReadOnly != "" {
+		return inHotStandby == "on" || defaultReadOnly == "on", nil
+	}
+
+	value, err := queryValue(ctx, s, "show transaction_read_only")
+	if err != nil {
+		return false, err
+	}
+	return value == "on", nil
+}
+
+// isStandby uses the in_hot_standby parameter reported by PostgreSQL 14 and later, or asks the server.
+func isStandby(ctx context.Context, s Session) (bool, error) {
+	if inHotStandby := s.ParameterStatus("in_hot_standby"); inHotStandby != "" {
+		return inHotStandby == "on", nil
+	}
+
+	value, err := queryValue(ctx, s, "select pg_is_in_recovery()")
+	if err != nil {
+		return false, err
+	}
+	return value == "t", nil
+}
+
+func queryValue(ctx context.Context, s Session, sql string) (string, error) {
+	rows, err := s.Exec(ctx, sql)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		return "", fmt.Errorf("unexpected result of %s", sql)
+	}
+	return string(rows[0][0]), nil
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package cfg
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type testSession struct {
+	params  map[string]string
+	results map[string]string
+}
+
+func (s *testSession) Exec(_ context.Context, sql string) ([][][]byte, error) {
+	value, ok := s.results[sql]
+	if !ok {
+		return nil, errors.New("unexpected query " + sql)
+	}
+	return [][][]byte{{[]byte(value)}}, nil
+}
+
+func (s *testSession) ParameterStatus(key string) string {
+	return s.params[key]
+}
+
+func (s *testSession) PID() uint32 {
+	return 1
+}
+
+func TestTargetSessionAttrs(t *testing.T) {
+	primary := &testSession{results: map[string]string{"show transaction_read_only": "off", "select pg_is_in_recovery()": "f"}}
+	standby := &testSession{results: map[string]string{"show transaction_read_only": "on", "select pg_is_in_recovery()": "t"}}
+	reported := &testSession{params: map[string]string{"in_hot_standby": "on", "default_transaction_read_only": "off"}}
+
+	tests := []struct {
+		attrs   string
+		session Session
+		ok      bool
+	}{
+		{"read-write", primary, true},
+		{"read-write", standby, false},
+		{"read-write", reported, false},
+		{"read-only", primary, false},
+		{"read-only", standby, true},
+		{"primary", primary, true},
+		{"primary", reported, false},
+		{"standby", standby, true},
+		{"standby", primary, false},
+		{"prefer-standby", standby, true},
+		{"prefer-standby", reported, true},
+	}
+
+	for _, tt := range tests {
+		err := targetSessionAttrs[tt.attrs](context.Background(), tt.session)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error %v", tt.attrs, err)
+		}
+	}
+
+	var notPreferredErr *NotPreferredError
+	err := ValidateConnectTargetSessionAttrsPreferStandby(context.Background(), primary)
+	if !errors.As(err, &notPreferredErr) {
+		t.Errorf("prefer-standby: expected NotPreferredError, got %v", err)
+	}
+}
+
+func TestParseConfigTargetSessionAttrs(t *testing.T) {
+	var c Config
+	if err := c.ParseConfig("host=localhost target_session_attrs=standby"); err != nil {
+		t.Fatal(err)
+	}
+	if c.ValidateConnect == nil {
+		t.Error("ValidateConnect is not set")
+	}
+
+	if err := c.ParseConfig("host=localhost target_session_attrs=unknown"); err == nil {
+		t.Error("expected error for unknown target_session_attrs")
+	}
+}
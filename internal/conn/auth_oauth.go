@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+// OAUTHBEARER authentication
+//
+// Resources:
+//   https://tools.ietf.org/html/rfc7628
+//   https://www.postgresql.org/docs/current/sasl-authentication.html#SASL-OAUTHBEARER
+
+package conn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"pap/internal/cfg"
+	"pap/internal/pgproto"
+)
+
+const oauthBearer = "OAUTHBEARER"
+
+// saslAuth performs SASL authentication with the mechanism selected from serverAuthMechanisms: OAUTHBEARER if the
+// config has an OAuthTokenProvider, SCRAM otherwise. channelBound reports whether SCRAM-SHA-256-PLUS was used.
+func (c *connection) saslAuth(serverAuthMechanisms []string, config *cfg.Config, host string, oauthFailure *cfg.OAuthError) (channelBound bool, err error) {
+	switch saslMethod(serverAuthMechanisms, config) {
+	case cfg.AuthOAuth:
+		return false, c.oauthAuth(config, host, oauthFailure)
+	case cfg.AuthSCRAMSHA256:
+		password, err := configPassword(config, host)
+		if err != nil {
+			return false, err
+		}
+		return c.scramAuth(serverAuthMechanisms, config, password)
+	default:
+		return false, errors.New("server requested OAUTHBEARER authentication, but the config has no OAuthTokenProvider")
+	}
+}
+
+// saslMethod returns the require_auth method of the SASL mechanism saslAuth selects, empty if there is none.
+func saslMethod(serverAuthMechanisms []string, config *cfg.Config) string {
+	hasOAuth := false
+	for _, mech := range serverAuthMechanisms {
+		hasOAuth = hasOAuth || mech == oauthBearer
+	}
+
+	switch {
+	case hasOAuth && config.OAuthTokenProvider != nil:
+		return cfg.AuthOAuth
+	case hasOAuth && len(serverAuthMechanisms) == 1:
+		return ""
+	default:
+		return cfg.AuthSCRAMSHA256
+	}
+}
+
+// oauthAuth sends the bearer token of config.OAuthTokenProvider. If the server rejects it, the attempt ends with an
+// *cfg.OAuthError, which is passed to the provider on the retry.
+func (c *connection) oauthAuth(config *cfg.Config, host string, failure *cfg.OAuthError) error {
+	ctx, cancel := connectContext(config)
+	defer cancel()
+
+	token, err := config.OAuthTokenProvider(ctx, cfg.OAuthTokenRequest{Host: host, User: config.User, Failure: failure})
+	if err != nil {
+		return fmt.Errorf("OAuthTokenProvider failed: %w", err)
+	}
+
+	// client-initial-response of RFC 7628 without authzid, the key-value pairs are separated by 0x01
+	saslInitialResponse := &pgproto.SASLInitialResponse{
+		AuthMechanism: oauthBearer,
+		Data:          []byte("n,,\x01auth=Bearer " + token + "\x01\x01"),
+	}
+	if _, err := c.conn.Write(saslInitialResponse.Encode(nil)); err != nil {
+		return err
+	}
+
+	// on success the server continues with AuthenticationOk, left to the caller
+	msg, err := c.peekMessage()
+	if err != nil {
+		return err
+	}
+	saslContinue, ok := msg.(*pgproto.AuthenticationSASLContinue)
+	if !ok {
+		return nil
+	}
+	c.peekedMsg = nil
+
+	var status struct {
+		Status              string `json:"status"`
+		Scope               string `json:"scope"`
+		OpenIDConfiguration string `json:"openid-configuration"`
+	}
+	if err := json.Unmarshal(saslContinue.Data, &status); err != nil {
+		return fmt.Errorf("invalid OAUTHBEARER error status received from server: %w", err)
+	}
+	oauthErr := &cfg.OAuthError{Status: status.Status, Scope: status.Scope, OpenIDConfiguration: status.OpenIDConfiguration}
+
+	// the client must acknowledge the error with a single 0x01, then the server fails the authentication
+	saslResponse := &pgproto.SASLResponse{Data: []byte{0x01}}
+	if _, err := c.conn.Write(saslResponse.Encode(nil)); err != nil {
+		return err
+	}
+	_, oauthErr.Err = c.receiveMessage()
+	if oauthErr.Err == nil {
+		oauthErr.Err = errors.New("expected ErrorResponse message but received unexpected message")
+	}
+	return oauthErr
+}
@@ -7,6 +7,7 @@
 // Resources:
 //   https://tools.ietf.org/html/rfc5802
 //   https://tools.ietf.org/html/rfc8265
+//   https://tools.ietf.org/html/rfc5929#section-4 (tls-server-end-point)
 //   https://www.postgresql.org/docs/current/sasl-authentication.html
 //
 // Inspiration drawn from other implementations:
@@ -18,9 +19,12 @@ package conn
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -35,31 +39,50 @@ import (
 
 const clientNonceLen = 18
 
-// Perform SCRAM authentication.
-func (c *connection) scramAuth(serverAuthMechanisms []string, config *cfg.Config) error {
-	sc, err := newScramClient(serverAuthMechanisms, config.Password)
+const (
+	scramSHA256     = "SCRAM-SHA-256"
+	scramSHA256Plus = "SCRAM-SHA-256-PLUS"
+)
+
+// ErrChannelBindingRequired is returned with channel_binding=require if the server did not authenticate with
+// SCRAM-SHA-256-PLUS.
+var ErrChannelBindingRequired = errors.New("channel binding required, but server authenticated client without channel binding")
+
+// Perform SCRAM authentication with password. channelBound reports whether SCRAM-SHA-256-PLUS was used.
+func (c *connection) scramAuth(serverAuthMechanisms []string, config *cfg.Config, password string) (channelBound bool, err error) {
+	var cbData []byte
+	if config.ChannelBinding != cfg.ChannelBindingDisable {
+		if state := c.tlsConnectionState(); state != nil {
+			cbData, err = tlsServerEndPoint(state)
+			if err != nil && config.ChannelBinding == cfg.ChannelBindingRequire {
+				return false, err
+			}
+		}
+	}
+
+	sc, err := newScramClient(serverAuthMechanisms, password, cbData, config.ChannelBinding == cfg.ChannelBindingRequire)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Send client-first-message in a SASLInitialResponse
 	saslInitialResponse := &pgproto.SASLInitialResponse{
-		AuthMechanism: "SCRAM-SHA-256",
+		AuthMechanism: sc.authMechanism,
 		Data:          sc.clientFirstMessage(),
 	}
 	_, err = c.conn.Write(saslInitialResponse.Encode(nil))
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Receive server-first-message payload in a AuthenticationSASLContinue.
 	saslContinue, err := c.rxSASLContinue()
 	if err != nil {
-		return err
+		return false, err
 	}
 	err = sc.recvServerFirstMessage(saslContinue.Data)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Send client-final-message in a SASLResponse
@@ -68,15 +91,58 @@ func (c *connection) scramAuth(serverAuthMechanisms []string, config *cfg.Config
 	}
 	_, err = c.conn.Write(saslResponse.Encode(nil))
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Receive server-final-message payload in a AuthenticationSASLFinal.
 	saslFinal, err := c.rxSASLFinal()
 	if err != nil {
-		return err
+		return false, err
+	}
+	if err := sc.recvServerFinalMessage(saslFinal.Data); err != nil {
+		return false, err
+	}
+	return sc.authMechanism == scramSHA256Plus, nil
+}
+
+// tlsConnectionState returns the state of the TLS connection, or nil without TLS.
+func (c *connection) tlsConnectionState() *tls.ConnectionState {
+	conn := c.conn
+	if dc, ok := conn.(*deadlineConn); ok {
+		conn = dc.Conn
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	return &state
+}
+
+// tlsServerEndPoint returns the tls-server-end-point channel binding data, the hash of the server certificate. The
+// hash function is the one of the certificate signature, MD5 and SHA-1 are replaced by SHA-256.
+func tlsServerEndPoint(state *tls.ConnectionState) ([]byte, error) {
+	if len(state.PeerCertificates) == 0 {
+		return nil, errors.New("no server certificate for channel binding")
+	}
+	cert := state.PeerCertificates[0]
+
+	var hash crypto.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1,
+		x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.ECDSAWithSHA256, x509.DSAWithSHA256:
+		hash = crypto.SHA256
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm of server certificate for channel binding: %v", cert.SignatureAlgorithm)
 	}
-	return sc.recvServerFinalMessage(saslFinal.Data)
+
+	h := hash.New()
+	h.Write(cert.Raw)
+	return h.Sum(nil), nil
 }
 
 func (c *connection) rxSASLContinue() (*pgproto.AuthenticationSASLContinue, error) {
@@ -107,9 +173,13 @@ func (c *connection) rxSASLFinal() (*pgproto.AuthenticationSASLFinal, error) {
 
 type scramClient struct {
 	serverAuthMechanisms []string
+	authMechanism        string
 	password             []byte
 	clientNonce          []byte
 
+	gs2Header []byte // the GS2 header with the channel binding flag
+	cbData    []byte // tls-server-end-point data, only with SCRAM-SHA-256-PLUS
+
 	clientFirstMessageBare []byte
 
 	serverFirstMessage   []byte
@@ -121,22 +191,41 @@ type scramClient struct {
 	authMessage    []byte
 }
 
-func newScramClient(serverAuthMechanisms []string, password string) (scramClient, error) {
+// newScramClient selects SCRAM-SHA-256-PLUS if cbData of the TLS connection is given and the server supports it,
+// otherwise SCRAM-SHA-256 unless requireChannelBinding is set.
+func newScramClient(serverAuthMechanisms []string, password string, cbData []byte, requireChannelBinding bool) (scramClient, error) {
 	sc := scramClient{
 		serverAuthMechanisms: serverAuthMechanisms,
 	}
 
-	// Ensure server supports SCRAM-SHA-256
-	hasScramSHA256 := false
+	hasScramSHA256, hasScramSHA256Plus := false, false
 	for _, mech := range sc.serverAuthMechanisms {
-		if mech == "SCRAM-SHA-256" {
+		switch mech {
+		case scramSHA256:
 			hasScramSHA256 = true
-			break
+		case scramSHA256Plus:
+			hasScramSHA256Plus = true
 		}
 	}
 
-	if !hasScramSHA256 {
+	switch {
+	case cbData != nil && hasScramSHA256Plus:
+		sc.authMechanism = scramSHA256Plus
+		sc.gs2Header = []byte("p=tls-server-end-point,,")
+		sc.cbData = cbData
+	case requireChannelBinding && cbData == nil:
+		return sc, errors.New("channel binding required, but the connection does not use TLS")
+	case requireChannelBinding:
+		return sc, errors.New("channel binding required, but server does not support SCRAM-SHA-256-PLUS")
+	case !hasScramSHA256:
 		return sc, errors.New("server does not support SCRAM-SHA-256")
+	case cbData != nil:
+		// the client supports channel binding, but the server does not offer it
+		sc.authMechanism = scramSHA256
+		sc.gs2Header = []byte("y,,")
+	default:
+		sc.authMechanism = scramSHA256
+		sc.gs2Header = []byte("n,,")
 	}
 
 	// precis.OpaqueString is equivalent to SASLprep for password.
@@ -160,7 +249,7 @@ func newScramClient(serverAuthMechanisms []string, password string) (scramClient
 
 func (sc *scramClient) clientFirstMessage() []byte {
 	sc.clientFirstMessageBare = []byte(fmt.Sprintf("n=,r=%s", sc.clientNonce))
-	return []byte(fmt.Sprintf("n,,%s", sc.clientFirstMessageBare))
+	return []byte(fmt.Sprintf("%s%s", sc.gs2Header, sc.clientFirstMessageBare))
 }
 
 func (sc *scramClient) recvServerFirstMessage(serverFirstMessage []byte) error {
@@ -219,7 +308,8 @@ func (sc *scramClient) recvServerFirstMessage(serverFirstMessage []byte) error {
 }
 
 func (sc *scramClient) clientFinalMessage() string {
-	clientFinalMessageWithoutProof := []byte(fmt.Sprintf("c=biws,r=%s", sc.clientAndServerNonce))
+	channelBinding := base64.StdEncoding.EncodeToString(append(append([]byte{}, sc.gs2Header...), sc.cbData...))
+	clientFinalMessageWithoutProof := []byte(fmt.Sprintf("c=%s,r=%s", channelBinding, sc.clientAndServerNonce))
 
 	sc.saltedPassword = pbkdf2.Key([]byte(sc.password), sc.salt, sc.iterations, 32, sha256.New)
 	sc.authMessage = bytes.Join([][]byte{sc.clientFirstMessageBare, sc.serverFirstMessage, clientFinalMessageWithoutProof}, []byte(","))
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package conn
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+// testCertPEM is a self-signed certificate signed with ECDSA and SHA-256.
+const testCertPEM = `-----BEGIN CERTIFICATE-----
+MIIBfTCCASOgAwIBAgIULSEC7M3mLi/25obpfH/0dk0Ir5EwCgYIKoZIzj0EAwIw
+EzERMA8GA1UEAwwIcGFwIHRlc3QwIBcNMjYxMDE4MjIyNTAzWhgPMjEyNjA5MjQy
+MjI1MDNaMBMxETAPBgNVBAMMCHBhcCB0ZXN0MFkwEwYHKoZIzj0CAQYIKoZIzj0D
+AQcDQgAEOUPG7Dc15KWdUR3U02s+Da0lA+IxetBSGQfvPsnbjtTV4UiIqgA8X6KZ
+H/dvm5zxmk3SUvXjTwYrf1EqSYM+DaNTMFEwHQYDVR0OBBYEFLNk3fGpFTf5KMxU
+4RYo7JwXb+0fMB8GA1UdIwQYMBaAFLNk3fGpFTf5KMxU4RYo7JwXb+0fMA8GA1Ud
+EwEB/wQFMAMBAf8wCgYIKoZIzj0EAwIDSAAwRQIhAOXbeA/TJr+9SeGHiGzQhHQg
+N8lAr7ydXOeQS1LK+NxNAiBseDogGj2QLg0rsMTa7Ddil0Q2cXUrYfEumK/kK22L
+Sg==
+-----END CERTIFICATE-----
+`
+
+// the hashes of the DER encoding of testCertPEM
+const (
+	testCertSHA256 = "7bc565e8edd00be1d78f6c1082ca1543da754a25eafbf6b6a7bb2e25b6c9ee94"
+	testCertSHA384 = "c0c59993eb730699796e02151d106b4cb799361a88dd269c5930b8cd4b170faf9e91126b7898e4fc1edb63bff2aea780"
+	testCertSHA512 = "4ddbfafdc92231cf1dbd34495ad2b8806ad56dabdc4118e4e57bea067ae1c6990b33ca8c09d236c24216d33c877c1aae5fdf685efd401b782f2474b9eadf90b6"
+)
+
+func testCert(t *testing.T) *x509.Certificate {
+	block, _ := pem.Decode([]byte(testCertPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestTLSServerEndPoint(t *testing.T) {
+	tests := []struct {
+		algorithm x509.SignatureAlgorithm
+		hash      string
+	}{
+		{x509.ECDSAWithSHA256, testCertSHA256},
+		{x509.SHA256WithRSAPSS, testCertSHA256},
+		// MD5 and SHA-1 are replaced by SHA-256
+		{x509.MD5WithRSA, testCertSHA256},
+		{x509.SHA1WithRSA, testCertSHA256},
+		{x509.ECDSAWithSHA384, testCertSHA384},
+		{x509.SHA384WithRSA, testCertSHA384},
+		{x509.ECDSAWithSHA512, testCertSHA512},
+		{x509.SHA512WithRSAPSS, testCertSHA512},
+		{x509.PureEd25519, ""},
+	}
+	for _, tt := range tests {
+		cert := testCert(t)
+		cert.SignatureAlgorithm = tt.algorithm
+		cbData, err := tlsServerEndPoint(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+		if tt.hash == "" {
+			if err == nil {
+				t.Errorf("%v: expected error", tt.algorithm)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.algorithm, err)
+			continue
+		}
+		if hex.EncodeToString(cbData) != tt.hash {
+			t.Errorf("%v: expected hash %s, got %x", tt.algorithm, tt.hash, cbData)
+		}
+	}
+
+	if testCert(t).SignatureAlgorithm != x509.ECDSAWithSHA256 {
+		t.Error("unexpected signature algorithm of the test certificate")
+	}
+	if _, err := tlsServerEndPoint(&tls.ConnectionState{}); err == nil {
+		t.Error("expected error without server certificate")
+	}
+}
+
+func TestScramChannelBinding(t *testing.T) {
+	cbData, err := hex.DecodeString(testCertSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := []string{scramSHA256Plus, scramSHA256}
+
+	tests := []struct {
+		name       string
+		mechanisms []string
+		cbData     []byte
+		require    bool
+		mechanism  string
+		gs2Header  string
+	}{
+		{"plus", both, cbData, false, scramSHA256Plus, "p=tls-server-end-point,,"},
+		{"plus required", both, cbData, true, scramSHA256Plus, "p=tls-server-end-point,,"},
+		{"server without plus", []string{scramSHA256}, cbData, false, scramSHA256, "y,,"},
+		{"without TLS", both, nil, false, scramSHA256, "n,,"},
+		{"required without TLS", both, nil, true, "", ""},
+		{"required server without plus", []string{scramSHA256}, cbData, true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := newScramClient(tt.mechanisms, "secret", tt.cbData, tt.require)
+			if tt.mechanism == "" {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sc.authMechanism != tt.mechanism {
+				t.Errorf("expected %s, got %s", tt.mechanism, sc.authMechanism)
+			}
+
+			first := sc.clientFirstMessage()
+			if !bytes.HasPrefix(first, []byte(tt.gs2Header+"n=,r=")) {
+				t.Errorf("unexpected client-first-message %q", first)
+			}
+
+			salt := base64.StdEncoding.EncodeToString([]byte("salt"))
+			if err := sc.recvServerFirstMessage([]byte("r=" + string(sc.clientNonce) + "server,s=" + salt + ",i=4096")); err != nil {
+				t.Fatal(err)
+			}
+			final := sc.clientFinalMessage()
+			var expectedCB []byte
+			expectedCB = append(expectedCB, tt.gs2Header...)
+			if tt.mechanism == scramSHA256Plus {
+				expectedCB = append(expectedCB, cbData...)
+			}
+			prefix := "c=" + base64.StdEncoding.EncodeToString(expectedCB) + ",r=" + string(sc.clientNonce) + "server,p="
+			if !strings.HasPrefix(final, prefix) {
+				t.Errorf("expected client-final-message with prefix %q, got %q", prefix, final)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package conn
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"pap/internal/pgproto"
+)
+
+// watchCancel sends a cancel request if done is closed before the returned stop function is called. stop waits for
+// the cancel request, which must not outlive the command, or it could cancel the next one. A nil done is not watched.
+func (c *connection) watchCancel(done <-chan struct{}) (stop func()) {
+	if done == nil {
+		return func() {}
+	}
+
+	var wg sync.WaitGroup
+	stopChan := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-done:
+			_ = c.cancelRequest()
+		case <-stopChan:
+		}
+	}()
+	return func() {
+		close(stopChan)
+		wg.Wait()
+	}
+}
+
+// defaultCancelRequestTimeout limits a cancel request without ConnectTimeout.
+const defaultCancelRequestTimeout = 10 * time.Second
+
+// cancelRequest asks the server over a new connection to cancel the command in progress on c. It returns after the
+// server closed that connection, or after the ConnectTimeout of the config, so an unresponsive server does not block
+// the command.
+func (c *connection) cancelRequest() error {
+	remoteAddr := c.conn.RemoteAddr()
+	cancelConn, err := c.config.DialFunc(remoteAddr.Network(), remoteAddr.String())
+	if err != nil {
+		return err
+	}
+	defer cancelConn.Close()
+
+	timeout := c.config.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defaultCancelRequestTimeout
+	}
+	if err := cancelConn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	buf := (&pgproto.CancelRequest{ProcessID: c.pid, SecretKey: c.secretKey}).Encode(nil)
+	if _, err := cancelConn.Write(buf); err != nil {
+		return err
+	}
+
+	_, err = cancelConn.Read(buf)
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
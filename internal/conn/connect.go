@@ -5,24 +5,103 @@
 package conn
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/tls"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"strconv"
+	"time"
 
 	"pap/internal/cfg"
 	"pap/internal/pgproto"
 )
 
-func (c *connection) connect(config *cfg.Config, fallbackConfig *cfg.FallbackConfig) error {
+// connectConfig connects to the host of config or, if it fails, to the first of its fallbacks that succeeds. The hosts
+// are reordered by the Balancer of config. Like libpq it does not try further hosts after an authentication failure.
+func (c *connection) connectConfig(config *cfg.Config) error {
+	fallbacks := fallbackConfigs(config)
+	if config.Balancer != nil {
+		fallbacks = config.Balancer.Order(fallbacks)
+		defer func() {
+			config.Balancer.Connected(fallbacks[0], c.host)
+		}()
+	}
+
+	notPreferred := -1
+	var err error
+	for i, fallbackConfig := range fallbacks {
+		err = c.connectHost(config, fallbackConfig, false)
+		if err == nil {
+			c.fallback, c.notPreferred = i, false
+			return nil
+		}
+
+		var notPreferredErr *cfg.NotPreferredError
+		if errors.As(err, &notPreferredErr) {
+			if notPreferred < 0 {
+				notPreferred = i
+			}
+			continue
+		}
+		var pgErr *PgError
+		if errors.As(err, &pgErr) {
+			const (
+				ERRCODE_INVALID_PASSWORD                    = "28P01" // wrong password
+				ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION = "28000" // db does not exist
+			)
+			// pg_hba.conf may reject the connection with or without TLS only, so like libpq sslmode=allow and prefer
+			// try the host again with the other TLS setting, but no further hosts
+			if (pgErr.Code == ERRCODE_INVALID_PASSWORD || pgErr.Code == ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION) &&
+				!(i+1 < len(fallbacks) && sameHost(fallbacks[i+1], fallbackConfig)) {
+				return err
+			}
+		}
+	}
+
+	if notPreferred >= 0 {
+		err = c.connectHost(config, fallbacks[notPreferred], true)
+		if err == nil {
+			c.fallback, c.notPreferred = notPreferred, true
+		}
+	}
+	return err
+}
+
+// sameHost reports whether a and b are fallbacks of the same host, which differ in the TLS config.
+func sameHost(a, b *cfg.FallbackConfig) bool {
+	return a.Host == b.Host && a.Port == b.Port
+}
+
+// fallbackConfigs returns the hosts of config in the order of preference, the host of config first.
+func fallbackConfigs(config *cfg.Config) []*cfg.FallbackConfig {
+	fallbacks := make([]*cfg.FallbackConfig, 0, len(config.Fallbacks)+1)
+	fallbacks = append(fallbacks, &cfg.FallbackConfig{Host: config.Host, Port: config.Port, TLSConfig: config.TLSConfig})
+	return append(fallbacks, config.Fallbacks...)
+}
+
+// connectHost connects to the host of fallbackConfig like connect. If the server rejects the OAuth bearer token, it
+// tries once more with a token requested for the rejection.
+func (c *connection) connectHost(config *cfg.Config, fallbackConfig *cfg.FallbackConfig, ignoreNotPreferred bool) error {
+	err := c.connect(config, fallbackConfig, ignoreNotPreferred, nil)
+	var oauthErr *cfg.OAuthError
+	if errors.As(err, &oauthErr) {
+		err = c.connect(config, fallbackConfig, ignoreNotPreferred, oauthErr)
+	}
+	return err
+}
+
+// connect connects to the host of fallbackConfig. If ignoreNotPreferred is set, a NotPreferredError of ValidateConnect
+// is ignored. oauthFailure is passed to the OAuthTokenProvider of config.
+func (c *connection) connect(config *cfg.Config, fallbackConfig *cfg.FallbackConfig, ignoreNotPreferred bool, oauthFailure *cfg.OAuthError) error {
 	c.cleanupDone = make(chan struct{})
-	config.RuntimeParams["application_name"] += strconv.Itoa(c.number)
+	c.config = config
 	var err error
-	network, address := cfg.NetworkAddress(config.Host, config.Port)
+	network, address := cfg.NetworkAddress(fallbackConfig.Host, fallbackConfig.Port)
 	conn, err := config.DialFunc(network, address)
 	c.conn = conn
 	if err != nil {
@@ -30,21 +109,28 @@ func (c *connection) connect(config *cfg.Config, fallbackConfig *cfg.FallbackCon
 		if errors.As(err, &netErr) && netErr.Timeout() {
 			err = &errTimeout{err: err}
 		}
-		return &connectError{config: config, msg: "dial error", err: err}
+		return &connectError{config: config, host: fallbackConfig.Host, msg: "dial error", err: err}
 	}
 
 	c.parameterStatuses = make(map[string]string)
 
 	if fallbackConfig.TLSConfig != nil {
-		if err := c.startTLS(fallbackConfig.TLSConfig); err != nil {
+		if err := c.startTLS(fallbackConfig.TLSConfig, config.SSLNegotiation == cfg.SSLNegotiationDirect, config.ConnectTimeout); err != nil {
 			c.conn.Close()
-			return &connectError{config: config, msg: "tls error", err: err}
+			msg := "tls error"
+			if config.SSLMode != "" {
+				msg += " with sslmode=" + config.SSLMode
+			}
+			return &connectError{config: config, host: fallbackConfig.Host, msg: msg, err: err}
 		}
 	}
 
 	c.status = statusConnecting
 
-	c.frontend = config.BuildFrontend(conn, conn)
+	if config.ReadTimeout > 0 || config.WriteTimeout > 0 {
+		c.conn = &deadlineConn{Conn: c.conn, readTimeout: config.ReadTimeout, writeTimeout: config.WriteTimeout}
+	}
+	c.frontend = config.BuildFrontend(c.conn, c.conn)
 
 	startupMsg := pgproto.StartupMessage{
 		ProtocolVersion: pgproto.ProtocolVersionNumber,
@@ -55,16 +141,18 @@ func (c *connection) connect(config *cfg.Config, fallbackConfig *cfg.FallbackCon
 	for k, v := range config.RuntimeParams {
 		startupMsg.Parameters[k] = v
 	}
+	startupMsg.Parameters["application_name"] += strconv.Itoa(c.number)
 
 	startupMsg.Parameters["user"] = config.User
 	if config.Database != "" {
 		startupMsg.Parameters["database"] = config.Database
 	}
 
-	if _, err := c.conn.Write(startupMsg.Encode(c.wBuf)); err != nil {
+	if _, err := c.conn.Write(startupMsg.Encode(c.wBuf[:0])); err != nil {
 		c.conn.Close()
-		return &connectError{config: config, msg: "failed to write startup message", err: err}
+		return &connectError{config: config, host: fallbackConfig.Host, msg: "failed to write startup message", err: err}
 	}
+	authMethod, channelBound := "", false
 	for {
 		msg, err := c.receiveMessage()
 		if err != nil {
@@ -72,7 +160,12 @@ func (c *connection) connect(config *cfg.Config, fallbackConfig *cfg.FallbackCon
 			if err, ok := err.(*PgError); ok {
 				return err
 			}
-			return &connectError{config: config, msg: "failed to receive message", err: err}
+			return &connectError{config: config, host: fallbackConfig.Host, msg: "failed to receive message", err: err}
+		}
+
+		if err := checkAuthRequirements(config, msg, authMethod, channelBound); err != nil {
+			c.conn.Close()
+			return &connectError{config: config, host: fallbackConfig.Host, msg: "failed to authenticate", err: err}
 		}
 
 		switch msg := msg.(type) {
@@ -82,41 +175,62 @@ func (c *connection) connect(config *cfg.Config, fallbackConfig *cfg.FallbackCon
 
 		case *pgproto.AuthenticationOk:
 		case *pgproto.AuthenticationCleartextPassword:
-			err = c.txPasswordMessage(c.wBuf, config.Password)
+			authMethod = cfg.AuthPassword
+			password, err := configPassword(config, fallbackConfig.Host)
 			if err != nil {
 				c.conn.Close()
-				return &connectError{config: config, msg: "failed to write password message", err: err}
+				return &connectError{config: config, host: fallbackConfig.Host, msg: "failed to get password", err: err}
+			}
+			err = c.txPasswordMessage(c.wBuf, password)
+			if err != nil {
+				c.conn.Close()
+				return &connectError{config: config, host: fallbackConfig.Host, msg: "failed to write password message", err: err}
 			}
 		case *pgproto.AuthenticationMD5Password:
-			digestedPassword := "md5" + hexMD5(hexMD5(config.Password+config.User)+string(msg.Salt[:]))
+			authMethod = cfg.AuthMD5
+			password, err := configPassword(config, fallbackConfig.Host)
+			if err != nil {
+				c.conn.Close()
+				return &connectError{config: config, host: fallbackConfig.Host, msg: "failed to get password", err: err}
+			}
+			digestedPassword := "md5" + hexMD5(hexMD5(password+config.User)+string(msg.Salt[:]))
 			err = c.txPasswordMessage(c.wBuf, digestedPassword)
 			if err != nil {
 				c.conn.Close()
-				return &connectError{config: config, msg: "failed to write password message", err: err}
+				return &connectError{config: config, host: fallbackConfig.Host, msg: "failed to write password message", err: err}
 			}
 		case *pgproto.AuthenticationSASL:
-			err = c.scramAuth(msg.AuthMechanisms, config)
+			authMethod = saslMethod(msg.AuthMechanisms, config)
+			channelBound, err = c.saslAuth(msg.AuthMechanisms, config, fallbackConfig.Host, oauthFailure)
 			if err != nil {
 				c.conn.Close()
-				return &connectError{config: config, msg: "failed SASL auth", err: err}
+				return &connectError{config: config, host: fallbackConfig.Host, msg: "failed SASL auth", err: err}
 			}
 
 		case *pgproto.ReadyForQuery:
 			c.status = statusIdle
-			//if config.ValidateConnect != nil {
-			//	// ValidateConnect may execute commands that cause the context to be watched again. Unwatch first to avoid
-			//	// the watch already in progress panic. This is that last thing done by this method so there is no need to
-			//	// restart the watch after ValidateConnect returns.
-			//	//
-			//	// See https://github.com/jackc/c/issues/40.
-			//	c.contextWatcher.Unwatch()
-			//
-			//	err := config.ValidateConnect(ctx, c)
-			//	if err != nil {
-			//		c.conn.Close()
-			//		return nil, &connectError{config: config, msg: "ValidateConnect failed", err: err}
-			//	}
-			//}
+			if config.ValidateConnect != nil {
+				ctx, cancel := connectContext(config)
+				defer cancel()
+
+				err := config.ValidateConnect(ctx, c)
+				var notPreferredErr *cfg.NotPreferredError
+				if err != nil && !(ignoreNotPreferred && errors.As(err, &notPreferredErr)) {
+					c.close()
+					return &connectError{config: config, host: fallbackConfig.Host, msg: "ValidateConnect failed", err: err}
+				}
+			}
+			if config.AfterConnect != nil {
+				ctx, cancel := connectContext(config)
+				defer cancel()
+
+				// a half initialized connection is not used, the attempt fails and pool connections reconnect
+				if err := config.AfterConnect(ctx, c); err != nil {
+					c.close()
+					return &connectError{config: config, host: fallbackConfig.Host, msg: "AfterConnect failed", err: err}
+				}
+			}
+			c.host = fallbackConfig
 			return nil
 		case *pgproto.ParameterStatus:
 			// handled by ReceiveMessage
@@ -125,27 +239,108 @@ func (c *connection) connect(config *cfg.Config, fallbackConfig *cfg.FallbackCon
 			return ErrorResponseToPgError(msg)
 		default:
 			c.conn.Close()
-			return &connectError{config: config, msg: "received unexpected message", err: err}
+			return &connectError{config: config, host: fallbackConfig.Host, msg: "received unexpected message", err: err}
 		}
 	}
 }
 
-func (c *connection) startTLS(tlsConfig *tls.Config) (err error) {
-	err = binary.Write(c.conn, binary.BigEndian, []int32{8, 80877103})
+// connectContext returns the context of the callbacks of a connection attempt, limited by the ConnectTimeout of config.
+func connectContext(config *cfg.Config) (context.Context, context.CancelFunc) {
+	if config.ConnectTimeout > 0 {
+		return context.WithTimeout(context.Background(), config.ConnectTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// configPassword returns the password for host, from the PasswordProvider of config if it is set, so that a rotated
+// password is used by the next connection.
+func configPassword(config *cfg.Config, host string) (string, error) {
+	if config.PasswordProvider == nil {
+		return config.Password, nil
+	}
+
+	ctx, cancel := connectContext(config)
+	defer cancel()
+	password, err := config.PasswordProvider(ctx, host, config.User)
 	if err != nil {
-		return
+		return "", fmt.Errorf("PasswordProvider failed: %w", err)
+	}
+	return password, nil
+}
+
+// checkAuthRequirements checks an authentication request of the server against the requirements of config before
+// it is answered, so that no password is sent to a server that may be a man in the middle. authMethod is the method
+// requested before, empty if the server did not request authentication yet.
+func checkAuthRequirements(config *cfg.Config, msg pgproto.BackendMessage, authMethod string, channelBound bool) error {
+	method := authMethod
+	switch msg := msg.(type) {
+	case *pgproto.AuthenticationOk:
+		if method == "" {
+			method = cfg.AuthNone
+		}
+	case *pgproto.AuthenticationCleartextPassword:
+		method = cfg.AuthPassword
+	case *pgproto.AuthenticationMD5Password:
+		method = cfg.AuthMD5
+	case *pgproto.AuthenticationSASL:
+		method = saslMethod(msg.AuthMechanisms, config)
+		if method == "" {
+			return nil // saslAuth fails
+		}
+	default:
+		return nil
 	}
 
-	response := make([]byte, 1)
-	if _, err = io.ReadFull(c.conn, response); err != nil {
-		return
+	if !config.RequireAuth.Allows(method) {
+		return fmt.Errorf("%w: server requested %s authentication, require_auth=%s", ErrAuthNotAllowed, method, config.RequireAuth)
 	}
+	// a SCRAM request is answered, scramAuth fails if the server does not offer SCRAM-SHA-256-PLUS
+	_, sasl := msg.(*pgproto.AuthenticationSASL)
+	if config.ChannelBinding == cfg.ChannelBindingRequire && !channelBound && !(sasl && method == cfg.AuthSCRAMSHA256) {
+		return ErrChannelBindingRequired
+	}
+	return nil
+}
+
+// startTLS sends SSLRequest and performs the TLS handshake, so certificate errors are reported here and not by the
+// first write. With direct the handshake starts immediately and the server must select the PostgreSQL ALPN protocol.
+// The handshake is limited by timeout if it is positive.
+func (c *connection) startTLS(tlsConfig *tls.Config, direct bool, timeout time.Duration) (err error) {
+	if !direct {
+		err = binary.Write(c.conn, binary.BigEndian, []int32{8, 80877103})
+		if err != nil {
+			return
+		}
 
-	if response[0] != 'S' {
-		return errors.New("server refused TLS connection")
+		response := make([]byte, 1)
+		if _, err = io.ReadFull(c.conn, response); err != nil {
+			return
+		}
+
+		switch response[0] {
+		case 'S':
+		case 'N':
+			return ErrTLSRefused
+		default:
+			return fmt.Errorf("unexpected response to SSLRequest: %q", response[0])
+		}
 	}
 
-	c.conn = tls.Client(c.conn, tlsConfig)
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	if timeout > 0 {
+		if err := tlsConn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		defer tlsConn.SetDeadline(time.Time{})
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	if direct && tlsConn.ConnectionState().NegotiatedProtocol != cfg.ALPNProtocol {
+		tlsConn.Close()
+		return fmt.Errorf("server did not select ALPN protocol %q for direct TLS connection", cfg.ALPNProtocol)
+	}
+	c.conn = tlsConn
 
 	return nil
 }
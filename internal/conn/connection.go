@@ -7,6 +7,8 @@ package conn
 import (
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"pap/internal/cfg"
 	"pap/internal/pgproto"
@@ -16,6 +18,15 @@ type Command struct {
 	CommandType byte
 	Query       *Query
 	Body        interface{}
+
+	// Pin pins the connection to the sender before the command is executed, e.g. for a transaction. A pinned
+	// connection is not announced as ready until a command with Unpin.
+	Pin   bool
+	Unpin bool
+
+	// Done cancels the command on the server when it is closed, e.g. by the context of the caller. A nil Done is not
+	// watched.
+	Done <-chan struct{}
 }
 
 type connection struct {
@@ -26,9 +37,10 @@ type connection struct {
 	txStatus          byte
 	frontend          *pgproto.Frontend
 
-	//config *cfg.Config
+	config *cfg.Config
 
-	status byte // One of connStatus* constants
+	status byte                // One of connStatus* constants
+	host   *cfg.FallbackConfig // the host of the established connection
 
 	bufferingReceive    bool
 	bufferingReceiveMux sync.Mutex
@@ -39,34 +51,69 @@ type connection struct {
 
 	cleanupDone chan struct{}
 
+	notifications chan<- Notification // set on a listening connection
+	listenDone    <-chan struct{}
+
+	pinned bool // the connection is not announced as ready
+
+	recycle    bool              // a closed connection is reconnected, set on pool connections
+	baseConfig *cfg.Config       // the config to reconnect with
+	statements map[string]string // the SQL of the prepared statements by name, prepared again on reconnect
+	txLost     bool              // the connection was lost while pinned, the commands fail until Unpin
+
+	fallback      int         // the index of the connected host in the fallback configs, 0 is the preferred host
+	notPreferred  bool        // the host was rejected by ValidateConnect as not preferred
+	failbackTimer *time.Timer // fires to try to move back to a more preferred host
+
+	// failbackResult receives the connection to a more preferred host dialed in the background, nil if the attempt
+	// failed. It is nil unless an attempt is in progress.
+	failbackResult chan *connection
+
 	//new
 	number        int
 	commandChan   chan Command
 	connReadyChan chan int
 
+	// idleSince receives the time in Unix nanoseconds the connection completed its last command, zero while it
+	// executes one. It is accessed atomically, nil if nobody tracks the idle time.
+	idleSince *int64
+	// created receives the time in Unix nanoseconds the connection was (re)connected. It is accessed atomically, nil
+	// if nobody tracks the lifetime.
+	created *int64
+
 	//buffers
 	wBuf   []byte
 	sufBuf []byte
 }
 
+// Start starts the goroutine of a connection which executes the commands of commandChan and announces its number on
+// connReadyChan. idleSince receives the time the connection completed its last command and created the time it was
+// (re)connected, they may be nil.
 func Start(
 	number int,
 	commandChan chan Command,
 	connReadyChan chan int,
+	idleSince *int64,
+	created *int64,
 ) {
-	go start(number, commandChan, connReadyChan)
+	go start(number, commandChan, connReadyChan, idleSince, created)
 }
 
 func start(
 	number int,
 	commandChan chan Command,
 	connReadyChan chan int,
+	idleSince *int64,
+	created *int64,
 ) {
 	var c = connection{
 		number:        number,
 		commandChan:   commandChan,
 		connReadyChan: connReadyChan,
+		idleSince:     idleSince,
+		created:       created,
 		wBuf:          make([]byte, 0, wbufLen),
+		statements:    make(map[string]string),
 	}
 	c.sufBuf = make([]byte, 0, 22)
 	c.sufBuf = (&pgproto.Describe{ObjectType: 'P'}).Encode(c.sufBuf)
@@ -76,7 +123,30 @@ func start(
 	var cmd Command
 
 	for {
-		cmd = <-commandChan
+		c.idle()
+		var failback <-chan time.Time
+		if c.failbackTimer != nil {
+			failback = c.failbackTimer.C
+		}
+		select {
+		case cmd = <-commandChan:
+			c.busy()
+		case <-failback:
+			c.failback()
+			continue
+		case next := <-c.failbackResult:
+			c.swapFailback(next)
+			continue
+		}
+		if c.txLost && cmd.CommandType != CommandDisconnect {
+			c.failCommand(cmd, ErrTxLost)
+			continue
+		}
+		if cmd.Pin {
+			c.pinned = true
+		} else if cmd.Unpin {
+			c.pinned = false
+		}
 		switch cmd.CommandType {
 		case CommandQuery:
 			c.ready()
@@ -86,21 +156,24 @@ func start(
 			cmd.Query.ready()
 		case CommandPrepare:
 			c.ready()
+			stop := c.watchCancel(cmd.Done)
 			c.prepare(
 				cmd.Query,
 			)
+			stop()
 			cmd.Query.ready()
 		case CommandPrepareAsync:
 			c.ready()
 			c.prepareAsync(
-				cmd.Query,
+				cmd.Body.(*PrepareAsync),
 			)
-			cmd.Query.Close()
 		case CommandPreparedQuery:
 			c.ready()
+			stop := c.watchCancel(cmd.Done)
 			c.ExecPrepared(
 				cmd.Query,
 			)
+			stop()
 			cmd.Query.ready()
 		case CommandFuncCache:
 			c.ExecParams(
@@ -109,20 +182,149 @@ func start(
 			c.ready()
 			cmd.Query.ready()
 		case CommandConnect:
-			err := c.connect(cmd.Body.(*cfg.Config), &cfg.FallbackConfig{})
-			if err != nil {
-				// TODO think about sync
-				panic(err)
+			config := cmd.Body.(*cfg.Config)
+			if cmd.Query == nil {
+				c.recycle = true
+				c.baseConfig = config.Copy()
+			}
+			err := c.connectConfig(config)
+			if cmd.Query != nil {
+				// the caller waits for the connect result on the query
+				cmd.Query.R.err = err
+				cmd.Query.ready()
+				if err != nil {
+					continue
+				}
+			} else if err != nil {
+				// a pool connection retries in the background, e.g. an unreachable replica is ejected meanwhile
+				if !c.reconnect() {
+					return
+				}
+				continue
+			} else {
+				c.scheduleFailback()
 			}
+			c.connected()
+			c.ready()
+		case CommandCopyFrom:
+			// a copy may run for long, the connection is announced after it, as after CopyTo
+			c.copyFrom(
+				cmd.Query,
+				cmd.Body.(*CopyFrom),
+			)
+			c.ready()
+			cmd.Query.ready()
+		case CommandCopyTo:
+			c.copyTo(
+				cmd.Query,
+				cmd.Body.(*CopyTo),
+			)
+			c.ready()
+			cmd.Query.ready()
+		case CommandSimpleQuery:
 			c.ready()
+			stop := c.watchCancel(cmd.Done)
+			c.simpleQuery(
+				cmd.Query,
+			)
+			stop()
+			cmd.Query.ready()
+		case CommandFunctionCall:
+			c.ready()
+			c.functionCall(
+				cmd.Query,
+				cmd.Body.(*pgproto.FunctionCall),
+			)
+			cmd.Query.ready()
+		case CommandReplication:
+			c.replicate(cmd.Query, cmd.Body.(*Replication))
+			c.close()
+			return
+		case CommandListen:
+			c.listen(cmd.Query, cmd.Body.(*Listen))
+			c.close()
+			return
+		case CommandDeallocate:
+			c.ready()
+			c.deallocate(
+				cmd.Query,
+			)
+			cmd.Query.ready()
+		case CommandRetire:
+			c.retire()
+			c.ready()
+		case CommandDisconnect:
+			c.stopFailback()
+			c.close()
+			if cmd.Query != nil {
+				cmd.Query.ready()
+			}
+			return
+		}
+
+		if c.recycle && c.status == statusClosed {
+			if c.pinned {
+				c.txLost = true
+			}
+			if !c.reconnect() {
+				return
+			}
 		}
 	}
 }
 
+// close sends Terminate to the server and closes the underlying connection.
+func (c *connection) close() {
+	if c.host != nil {
+		if c.config.Balancer != nil {
+			c.config.Balancer.Disconnected(c.host)
+		}
+		c.host = nil
+	}
+	if c.conn == nil {
+		return
+	}
+	if c.status != statusClosed {
+		c.wBuf = (&pgproto.Terminate{}).Encode(c.wBuf[:0])
+		_, _ = c.conn.Write(c.wBuf)
+	}
+	c.status = statusClosed
+	_ = c.conn.Close()
+}
+
+// busy records that the connection executes a command.
+func (c *connection) busy() {
+	if c.idleSince != nil {
+		atomic.StoreInt64(c.idleSince, 0)
+	}
+}
+
+// idle records the completion of the command executed before, the time is kept while no other command is received.
+func (c *connection) idle() {
+	if c.idleSince != nil && atomic.LoadInt64(c.idleSince) == 0 {
+		atomic.StoreInt64(c.idleSince, time.Now().UnixNano())
+	}
+}
+
+// connected records that the connection was (re)connected now.
+func (c *connection) connected() {
+	if c.created != nil {
+		atomic.StoreInt64(c.created, time.Now().UnixNano())
+	}
+}
+
+// ready announces the connection unless it is pinned or has commands queued. The connections announced meanwhile may
+// fill connReadyChan, then it is announced in the background, so the connection does not block its commands.
 func (c *connection) ready() {
 	c.wBuf = c.wBuf[:0]
-	if len(c.commandChan) == 0 {
-		c.connReadyChan <- c.number
+	if !c.pinned && len(c.commandChan) == 0 {
+		select {
+		case c.connReadyChan <- c.number:
+		default:
+			go func(connReadyChan chan int, number int) {
+				connReadyChan <- number
+			}(c.connReadyChan, c.number)
+		}
 	}
 }
 
@@ -163,6 +365,8 @@ func (c *connection) ExecParams(
 			q.R.concludeCommand(nil, nil)
 		case *pgproto.DataRow:
 			q.R.rowValues = append(q.R.rowValues, msg.Values...)
+		case *pgproto.NoticeResponse:
+			q.R.notices = append(q.R.notices, NoticeResponseToNotice(msg))
 		case *pgproto.ErrorResponse:
 			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
 		case *pgproto.CommandComplete:
@@ -225,54 +429,47 @@ func (c *connection) prepare(q *Query) {
 		q.R.err = parseErr
 		return
 	}
+	c.statements[q.D.Name] = q.SQL
 }
 
-func (c *connection) prepareAsync(q *Query) {
-	c.wBuf = (&pgproto.Parse{Name: q.D.Name, Query: q.SQL, ParameterOIDs: q.D.paramOIDs}).Encode(c.wBuf)
-	c.wBuf = (&pgproto.Describe{ObjectType: 'S', Name: q.D.Name}).Encode(c.wBuf)
+// PrepareAsync is the body of CommandPrepareAsync. The connection prepares the statement of D, which another
+// connection prepared and described before, under the same name. The command has no query, a failure only leaves the
+// statement unprepared on the connection.
+type PrepareAsync struct {
+	SQL string
+	D   *Description
+}
+
+func (c *connection) prepareAsync(pa *PrepareAsync) {
+	c.wBuf = (&pgproto.Parse{Name: pa.D.Name, Query: pa.SQL, ParameterOIDs: pa.D.paramOIDs}).Encode(c.wBuf)
+	c.wBuf = (&pgproto.Describe{ObjectType: 'S', Name: pa.D.Name}).Encode(c.wBuf)
 	c.wBuf = (&pgproto.Sync{}).Encode(c.wBuf)
 
-	n, err := c.conn.Write(c.wBuf)
-	if err != nil {
+	if _, err := c.conn.Write(c.wBuf); err != nil {
 		// TODO close connection
 		c.status = statusClosed
-		q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
 		return
 	}
 
 	var parseErr error
-
-	for !q.R.commandConcluded {
+	for {
 		msg, err := c.receiveMessage()
 		if err != nil {
 			// TODO close connection
 			c.status = statusClosed
-			q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
 			return
 		}
 
 		switch msg := msg.(type) {
-		case *pgproto.ParameterDescription:
-			//q.D.paramOIDs = append(q.D.paramOIDs, msg.ParameterOIDs...)
-		case *pgproto.RowDescription:
-			// TODO Name cap 8000 try to reduce mb
-			//q.D.FieldDescriptions = append(q.D.FieldDescriptions, msg.Fields...)
 		case *pgproto.ErrorResponse:
 			parseErr = ErrorResponseToPgError(msg)
 		case *pgproto.ReadyForQuery:
-			q.R.commandConcluded = true
-			// TODO CHECK DOCS
-			//case *pgproto.ParseComplete:
-			//
-			//default:
-			//	panic("check")
+			if parseErr == nil {
+				c.statements[pa.D.Name] = pa.SQL
+			}
+			return
 		}
 	}
-
-	if parseErr != nil {
-		q.R.err = parseErr
-		return
-	}
 }
 
 func (c *connection) ExecPrepared(q *Query) {
@@ -307,6 +504,8 @@ func (c *connection) ExecPrepared(q *Query) {
 			q.R.concludeCommand(nil, nil)
 		case *pgproto.DataRow:
 			q.R.rowValues = append(q.R.rowValues, msg.Values...)
+		case *pgproto.NoticeResponse:
+			q.R.notices = append(q.R.notices, NoticeResponseToNotice(msg))
 		case *pgproto.ErrorResponse:
 			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
 		case *pgproto.CommandComplete:
@@ -322,3 +521,124 @@ func (c *connection) ExecPrepared(q *Query) {
 		}
 	}
 }
+
+// deallocate closes the prepared statement q.D.Name, it is not prepared again on reconnect.
+func (c *connection) deallocate(q *Query) {
+	c.wBuf = (&pgproto.Close{ObjectType: 'S', Name: q.D.Name}).Encode(c.wBuf)
+	c.wBuf = (&pgproto.Sync{}).Encode(c.wBuf)
+	delete(c.statements, q.D.Name)
+
+	n, err := c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
+		return
+	}
+
+	for {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			q.R.concludeCommand(nil, err)
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.ErrorResponse:
+			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
+		case *pgproto.ReadyForQuery:
+			return
+		}
+	}
+}
+
+// execSimple executes sql with the simple query protocol and returns the first error.
+func (c *connection) execSimple(sql string) error {
+	c.wBuf = (&pgproto.Query{String: sql}).Encode(c.wBuf[:0])
+	n, err := c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		return &writeError{err: err, safeToRetry: n == 0}
+	}
+
+	var queryErr error
+	for {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.ErrorResponse:
+			if queryErr == nil {
+				queryErr = ErrorResponseToPgError(msg)
+			}
+		case *pgproto.ReadyForQuery:
+			return queryErr
+		}
+	}
+}
+
+// simpleQuery executes q.SQL with the simple query protocol and saves the rows in the text format. Unlike the extended
+// protocol it works on replication connections.
+func (c *connection) simpleQuery(q *Query) {
+	c.wBuf = (&pgproto.Query{String: q.SQL}).Encode(c.wBuf[:0])
+	n, err := c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
+		return
+	}
+
+	for {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			q.R.concludeCommand(nil, err)
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.RowDescription:
+			q.D.FieldDescriptions = append(q.D.FieldDescriptions[:0], msg.Fields...)
+		case *pgproto.DataRow:
+			q.R.rowValues = append(q.R.rowValues, msg.Values...)
+		case *pgproto.NoticeResponse:
+			q.R.notices = append(q.R.notices, NoticeResponseToNotice(msg))
+		case *pgproto.ErrorResponse:
+			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
+		case *pgproto.CommandComplete:
+			q.R.concludeCommand(msg.CommandTag, nil)
+		case *pgproto.ReadyForQuery:
+			return
+		}
+	}
+}
+
+// functionCall calls a function with the fast-path interface. The result is saved as the only row value, nil for NULL.
+func (c *connection) functionCall(q *Query, fc *pgproto.FunctionCall) {
+	c.wBuf = fc.Encode(c.wBuf[:0])
+	n, err := c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
+		return
+	}
+
+	for {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			q.R.concludeCommand(nil, err)
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.FunctionCallResponse:
+			q.R.rowValues = append(q.R.rowValues, msg.Result)
+		case *pgproto.NoticeResponse:
+			q.R.notices = append(q.R.notices, NoticeResponseToNotice(msg))
+		case *pgproto.ErrorResponse:
+			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
+		case *pgproto.ReadyForQuery:
+			return
+		}
+	}
+}
@@ -18,6 +18,14 @@ const (
 	CommandFuncCache
 	CommandConnect
 	CommandDisconnect
+	CommandListen
+	CommandCopyFrom
+	CommandCopyTo
+	CommandSimpleQuery
+	CommandReplication
+	CommandFunctionCall
+	CommandRetire
+	CommandDeallocate
 )
 
 const wbufLen = 1024
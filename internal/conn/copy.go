@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package conn
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"pap/internal/pgproto"
+)
+
+// copyChunkLen is the maximum length of the data of one CopyData message.
+const copyChunkLen = 65536
+
+var ErrCopyCanceled = errors.New("copy canceled")
+
+// CopyFrom is the body of CommandCopyFrom. The connection executes the COPY FROM STDIN statement SQL and streams the
+// data of Reader in CopyData messages. Closing Done aborts the copy by CopyFail, Reader is not read after.
+type CopyFrom struct {
+	SQL    string
+	Reader io.Reader
+	Done   <-chan struct{}
+}
+
+func (c *connection) copyFrom(q *Query, cf *CopyFrom) {
+	c.wBuf = (&pgproto.Query{String: cf.SQL}).Encode(c.wBuf[:0])
+	n, err := c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
+		return
+	}
+
+	copyIn := false
+	for !copyIn {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			q.R.concludeCommand(nil, err)
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.CopyInResponse:
+			copyIn = true
+		case *pgproto.ErrorResponse:
+			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
+		case *pgproto.CommandComplete:
+			q.R.concludeCommand(nil, errors.New("statement is not COPY FROM STDIN"))
+		case *pgproto.ReadyForQuery:
+			return
+		}
+	}
+
+	copyErr := c.sendCopyData(cf)
+	if copyErr != nil {
+		q.R.concludeCommand(nil, copyErr)
+		c.wBuf = (&pgproto.CopyFail{Message: copyErr.Error()}).Encode(c.wBuf[:0])
+	} else {
+		c.wBuf = (&pgproto.CopyDone{}).Encode(c.wBuf[:0])
+	}
+	n, err = c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
+		return
+	}
+
+	for {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			q.R.concludeCommand(nil, err)
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.CommandComplete:
+			q.R.concludeCommand(msg.CommandTag, nil)
+		case *pgproto.ErrorResponse:
+			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
+		case *pgproto.ReadyForQuery:
+			return
+		}
+	}
+}
+
+// sendCopyData writes the data of cf.Reader in CopyData messages until EOF. Writing blocks while the server does not
+// read, which limits the reading of cf.Reader.
+func (c *connection) sendCopyData(cf *CopyFrom) error {
+	buf := make([]byte, 5+copyChunkLen)
+	buf[0] = 'd'
+
+	for {
+		select {
+		case <-cf.Done:
+			return ErrCopyCanceled
+		default:
+		}
+
+		n, readErr := cf.Reader.Read(buf[5:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[1:], uint32(n+4))
+			_, err := c.conn.Write(buf[:5+n])
+			if err != nil {
+				c.status = statusClosed
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// CopyTo is the body of CommandCopyTo. The connection executes the COPY TO STDOUT statement SQL and writes the data
+// of every CopyData message to Writer. Closing Done or a failed write cancels the statement on the server, the rest of
+// the data is discarded.
+type CopyTo struct {
+	SQL    string
+	Writer io.Writer
+	Done   <-chan struct{}
+}
+
+func (c *connection) copyTo(q *Query, ct *CopyTo) {
+	c.wBuf = (&pgproto.Query{String: ct.SQL}).Encode(c.wBuf[:0])
+	n, err := c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
+		return
+	}
+
+	defer c.watchCancel(ct.Done)()
+
+	copyOut := false
+	canceled := false
+	for {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			q.R.concludeCommand(nil, err)
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.CopyOutResponse:
+			copyOut = true
+		case *pgproto.CopyData:
+			if canceled {
+				continue
+			}
+			select {
+			case <-ct.Done:
+				canceled = true
+				q.R.concludeCommand(nil, ErrCopyCanceled)
+				continue
+			default:
+			}
+			if _, err := ct.Writer.Write(msg.Data); err != nil {
+				canceled = true
+				q.R.concludeCommand(nil, err)
+				_ = c.cancelRequest()
+			}
+		case *pgproto.CommandComplete:
+			if !copyOut {
+				q.R.concludeCommand(nil, errors.New("statement is not COPY TO STDOUT"))
+				continue
+			}
+			q.R.concludeCommand(msg.CommandTag, nil)
+		case *pgproto.ErrorResponse:
+			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
+		case *pgproto.ReadyForQuery:
+			return
+		}
+	}
+}
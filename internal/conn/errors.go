@@ -13,6 +13,12 @@ import (
 	"pap/internal/pgproto"
 )
 
+// ErrTLSRefused is returned if the server does not support TLS, e.g. it is not configured with ssl=on.
+var ErrTLSRefused = errors.New("server refused TLS connection")
+
+// ErrAuthNotAllowed is returned if the server requests an authentication method that require_auth does not allow.
+var ErrAuthNotAllowed = errors.New("authentication method not allowed by require_auth")
+
 type writeError struct {
 	err         error
 	safeToRetry bool
@@ -74,13 +80,18 @@ func (e *connectionError) Unwrap() error {
 
 type connectError struct {
 	config *cfg.Config
+	host   string // the host of the attempt, config.Host if empty
 	msg    string
 	err    error
 }
 
 func (e *connectError) Error() string {
+	host := e.host
+	if host == "" {
+		host = e.config.Host
+	}
 	sb := &strings.Builder{}
-	fmt.Fprintf(sb, "failed to connect to `host=%s user=%s database=%s`: %s", e.config.Host, e.config.User, e.config.Database, e.msg)
+	fmt.Fprintf(sb, "failed to connect to `host=%s user=%s database=%s`: %s", host, e.config.User, e.config.Database, e.msg)
 	if e.err != nil {
 		fmt.Fprintf(sb, " (%s)", e.err.Error())
 	}
@@ -173,6 +184,29 @@ func ErrorResponseToPgError(msg *pgproto.ErrorResponse) *PgError {
 	}
 }
 
+// NoticeResponseToNotice converts a wire protocol notice message to a *cfg.Notice.
+func NoticeResponseToNotice(msg *pgproto.NoticeResponse) *cfg.Notice {
+	return &cfg.Notice{
+		Severity:         msg.Severity,
+		Code:             msg.Code,
+		Message:          msg.Message,
+		Detail:           msg.Detail,
+		Hint:             msg.Hint,
+		Position:         msg.Position,
+		InternalPosition: msg.InternalPosition,
+		InternalQuery:    msg.InternalQuery,
+		Where:            msg.Where,
+		SchemaName:       msg.SchemaName,
+		TableName:        msg.TableName,
+		ColumnName:       msg.ColumnName,
+		DataTypeName:     msg.DataTypeName,
+		ConstraintName:   msg.ConstraintName,
+		File:             msg.File,
+		Line:             msg.Line,
+		Routine:          msg.Routine,
+	}
+}
+
 // SerializationError occurs on failure to encode or decode a value
 type SerializationError string
 
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package conn
+
+import (
+	"time"
+)
+
+// scheduleFailback starts the failback timer of a pool connection that is not connected to its most preferred host.
+// The hosts have no preference if they are load balanced.
+func (c *connection) scheduleFailback() {
+	c.stopFailback()
+	if !c.recycle || c.baseConfig.FailbackInterval <= 0 || c.baseConfig.Balancer != nil || len(c.preferredFallbacks()) == 0 {
+		return
+	}
+	c.failbackTimer = time.NewTimer(c.baseConfig.FailbackInterval)
+}
+
+// stopFailback stops the failback timer and discards the connection of an attempt in progress.
+func (c *connection) stopFailback() {
+	if c.failbackTimer != nil {
+		c.failbackTimer.Stop()
+		c.failbackTimer = nil
+	}
+	if c.failbackResult != nil {
+		go func(result <-chan *connection) {
+			if next := <-result; next != nil {
+				next.close()
+			}
+		}(c.failbackResult)
+		c.failbackResult = nil
+	}
+}
+
+// preferredFallbacks returns the indexes of the hosts to move back to: the hosts before the connected one, or all
+// other hosts if the connected host was accepted only as not preferred. The other fallbacks of the connected host,
+// e.g. with another sslmode, are skipped.
+func (c *connection) preferredFallbacks() []int {
+	fallbacks := fallbackConfigs(c.baseConfig)
+	current := fallbacks[c.fallback]
+
+	var preferred []int
+	for i, fallbackConfig := range fallbacks {
+		if sameHost(fallbackConfig, current) {
+			if c.notPreferred {
+				continue
+			}
+			break
+		}
+		preferred = append(preferred, i)
+	}
+	return preferred
+}
+
+// failback tries to move the connection to a more preferred host if it is reachable again. The preferred hosts are
+// dialed in the background, so the commands are not delayed by an unreachable host, and the new connection is passed
+// to swapFailback. A pinned connection is moved after it is unpinned.
+func (c *connection) failback() {
+	c.failbackTimer = nil
+	if c.pinned || c.status == statusClosed {
+		c.scheduleFailback()
+		return
+	}
+
+	config := c.baseConfig.Copy()
+	fallbacks := fallbackConfigs(config)
+	preferred := c.preferredFallbacks()
+	number := c.number
+	result := make(chan *connection, 1)
+	c.failbackResult = result
+	go func() {
+		for _, i := range preferred {
+			next := &connection{
+				number:     number,
+				wBuf:       make([]byte, 0, wbufLen),
+				statements: make(map[string]string),
+			}
+			if err := next.connectHost(config, fallbacks[i], false); err == nil {
+				next.fallback, next.notPreferred = i, false
+				result <- next
+				return
+			}
+		}
+		result <- nil
+	}()
+}
+
+// swapFailback takes over the connection of a failback attempt after the statements of c are prepared on it, so a
+// failed attempt does not affect the connection. The connection is kept if it was pinned or closed meanwhile.
+func (c *connection) swapFailback(next *connection) {
+	c.failbackResult = nil
+	if next != nil {
+		next.statements = c.statements
+		if c.pinned || c.status == statusClosed {
+			next.close()
+		} else if err := next.prepareStatements(); err != nil {
+			next.close()
+		} else {
+			c.swap(next)
+			c.connected()
+		}
+	}
+
+	c.scheduleFailback()
+}
+
+// next returns an unconnected connection to replace c, with the statements of c.
+func (c *connection) next() *connection {
+	return &connection{
+		number:     c.number,
+		wBuf:       make([]byte, 0, wbufLen),
+		statements: c.statements,
+	}
+}
+
+// swap closes the connection of c and takes over the connection of next.
+func (c *connection) swap(next *connection) {
+	c.close()
+	c.conn = next.conn
+	c.pid = next.pid
+	c.secretKey = next.secretKey
+	c.parameterStatuses = next.parameterStatuses
+	c.txStatus = next.txStatus
+	c.frontend = next.frontend
+	c.config = next.config
+	c.status = next.status
+	c.peekedMsg = nil
+	c.cleanupDone = next.cleanupDone
+	c.host = next.host
+	c.fallback, c.notPreferred = next.fallback, next.notPreferred
+}
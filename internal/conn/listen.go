@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package conn
+
+import (
+	"strings"
+	"time"
+)
+
+// Notification is a message received from the PostgreSQL LISTEN/NOTIFY system.
+type Notification struct {
+	PID     uint32 // backend pid that sent the notification
+	Channel string // channel from which notification was received
+	Payload string
+
+	// Gap is set on the event emitted instead of a notification after the listening connection was restored.
+	// Notifications sent while it was lost are missing, so consumers should resync. Other fields are empty then.
+	Gap bool
+}
+
+// Listen is the body of CommandListen. The connection executes LISTEN for Channels and delivers notifications to
+// Notifications until Done is closed or the connection fails, then the connection is closed. If Gap is set, the gap
+// event is delivered after LISTEN, before any notification.
+//
+// The command query is released after LISTEN with its result. When listening ends, the reason is sent to End, which
+// must be buffered, nil if Done was closed.
+type Listen struct {
+	Channels      []string
+	Notifications chan<- Notification
+	Done          <-chan struct{}
+	End           chan<- error
+	Gap           bool
+}
+
+func (c *connection) listen(q *Query, l *Listen) {
+	sb := strings.Builder{}
+	for i := range l.Channels {
+		sb.WriteString("listen ")
+		sb.WriteString(QuoteIdentifier(l.Channels[i]))
+		sb.WriteString(";")
+	}
+
+	q.R.err = c.execSimple(sb.String())
+	if q.R.err != nil {
+		q.ready()
+		return
+	}
+
+	c.notifications = l.Notifications
+	c.listenDone = l.Done
+	c.disableReadTimeout()
+	q.ready()
+
+	if l.Gap {
+		select {
+		case l.Notifications <- Notification{Gap: true}:
+		case <-l.Done:
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-l.Done:
+			// interrupt the blocked read
+			_ = c.conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	for {
+		_, err := c.receiveMessage()
+		if err != nil {
+			select {
+			case <-l.Done:
+				err = nil
+			default:
+			}
+			l.End <- err
+			return
+		}
+	}
+}
+
+// QuoteIdentifier quotes s as an SQL identifier.
+func QuoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -5,8 +5,10 @@
 package conn
 
 import (
+	"context"
 	"errors"
 	"net"
+	"time"
 
 	"pap/internal/pgproto"
 )
@@ -80,13 +82,16 @@ func (c *connection) receiveMessage() (pgproto.BackendMessage, error) {
 			return nil, ErrorResponseToPgError(msg)
 		}
 	case *pgproto.NoticeResponse:
-		//if c.config.OnNotice != nil {
-		//	c.config.OnNotice(c, noticeResponseToNotice(msg))
-		//}
+		if c.config != nil && c.config.OnNotice != nil {
+			c.config.OnNotice(c.number, c.pid, NoticeResponseToNotice(msg))
+		}
 	case *pgproto.NotificationResponse:
-		//if c.config.OnNotification != nil {
-		//	c.config.OnNotification(c, &Notification{PID: msg.PID, Channel: msg.Channel, Payload: msg.Payload})
-		//}
+		if c.notifications != nil {
+			select {
+			case c.notifications <- Notification{PID: msg.PID, Channel: msg.Channel, Payload: msg.Payload}:
+			case <-c.listenDone:
+			}
+		}
 	}
 
 	return msg, nil
@@ -101,3 +106,55 @@ func (c *connection) Conn() net.Conn {
 func (c *connection) PID() uint32 {
 	return c.pid
 }
+
+// ParameterStatus returns the value of a parameter reported by the server or "" if it was not reported.
+func (c *connection) ParameterStatus(key string) string {
+	return c.parameterStatuses[key]
+}
+
+// Exec executes sql with the simple query protocol and returns the rows of the last statement. It is used by the
+// connect hooks.
+func (c *connection) Exec(ctx context.Context, sql string) ([][][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer c.conn.SetDeadline(time.Time{})
+	}
+
+	c.wBuf = (&pgproto.Query{String: sql}).Encode(c.wBuf[:0])
+	n, err := c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		return nil, &writeError{err: err, safeToRetry: n == 0}
+	}
+
+	var rows [][][]byte
+	var queryErr error
+	for {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.RowDescription:
+			rows = rows[:0]
+		case *pgproto.DataRow:
+			// the message is reused, its values are not
+			rows = append(rows, append([][]byte(nil), msg.Values...))
+		case *pgproto.ErrorResponse:
+			if queryErr == nil {
+				queryErr = ErrorResponseToPgError(msg)
+			}
+		case *pgproto.ReadyForQuery:
+			if queryErr != nil {
+				return nil, queryErr
+			}
+			return rows, nil
+		}
+	}
+}
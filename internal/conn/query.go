@@ -22,6 +22,7 @@ type Query struct {
 	paramValueBytes []byte
 
 	startTime      int64
+	d              Description // own description of the unnamed statement, D points to it after Start
 	D              *Description
 	R              Result
 	Mutex          sync.RWMutex
@@ -30,7 +31,7 @@ type Query struct {
 }
 
 func NewQuery(connInfo *pgtype.ConnInfo, emptyQueryChan chan *Query) *Query {
-	return &Query{
+	q := &Query{
 		SQL:             "",
 		Args:            make([]interface{}, 0, 16),
 		paramFormats:    make([]int16, 0, 128),
@@ -40,7 +41,7 @@ func NewQuery(connInfo *pgtype.ConnInfo, emptyQueryChan chan *Query) *Query {
 			connInfo:  connInfo,
 			rowValues: make([][]byte, 0, 256),
 		},
-		D: &Description{
+		d: Description{
 			FieldDescriptions: make([]pgproto.FieldDescription, 0, 128),
 			paramOIDs:         make([]uint32, 0, 128),
 			resultFormats:     make([]int16, 0, 128),
@@ -48,6 +49,8 @@ func NewQuery(connInfo *pgtype.ConnInfo, emptyQueryChan chan *Query) *Query {
 		emptyQueryChan: emptyQueryChan,
 		used:           false,
 	}
+	q.D = &q.d
+	return q
 }
 
 func (q *Query) Actual() bool {
@@ -64,6 +67,13 @@ func (q *Query) ready() {
 	q.Mutex.Unlock()
 }
 
+// Fail concludes the query with err without executing it, e.g. if it could not get a connection in time. The caller
+// waiting for the result gets err.
+func (q *Query) Fail(err error) {
+	q.R.concludeCommand(nil, err)
+	q.ready()
+}
+
 func (q *Query) Return() {
 	q.emptyQueryChan <- q
 }
@@ -75,11 +85,16 @@ func (q *Query) Start(sql string, args ...interface{}) error {
 	q.Args = q.Args[:0]
 	q.R.rowValues = q.R.rowValues[:0]
 
-	q.D.FieldDescriptions = q.D.FieldDescriptions[:0]
-	q.D.paramOIDs = q.D.paramOIDs[:0]
-	q.D.resultFormats = q.D.resultFormats[:0]
+	// D may still point to a cached prepared statement description from the
+	// previous use, so only the own description is reset.
+	q.d.FieldDescriptions = q.d.FieldDescriptions[:0]
+	q.d.paramOIDs = q.d.paramOIDs[:0]
+	q.d.resultFormats = q.d.resultFormats[:0]
+	q.d.scanPlans = nil
+	q.D = &q.d
 
 	q.R.commandConcluded = false
+	q.R.notices = q.R.notices[:0]
 
 	if q.R.err != nil {
 		q.R.err = nil
@@ -96,13 +111,6 @@ func (q *Query) Start(sql string, args ...interface{}) error {
 	return nil
 }
 
-// NoticeHandler is a function that can handle notices received from the PostgreSQL server. Notices can be received at
-// any time, usually during handling of a query response. The *connection is provided so the handler is aware of the origin
-// of the notice, but it must not invoke any query method. Be aware that this is distinct from LISTEN/NOTIFY
-// notification.
-// TODO notice
-//type NoticeHandler func(*connection, *Notice)
-
 // NotificationHandler is a function that can handle notifications received from the PostgreSQL server. Notifications
 // can be received at any time, usually during handling of a query response. The *connection is provided so the handler is
 // aware of the origin of the notice, but it must not invoke any query method. Be aware that this is distinct from a
@@ -113,7 +121,7 @@ func (q *Query) Start(sql string, args ...interface{}) error {
 func (q *Query) Scan(dest interface{}) error {
 
 	if q.R.err != nil {
-		err := fmt.Errorf("error: %s", q.R.err.Error())
+		err := fmt.Errorf("error: %w", q.R.err)
 		return err
 	}
 
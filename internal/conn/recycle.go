@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"pap/internal/pgproto"
+)
+
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+)
+
+// ErrTxLost is returned for the commands of a transaction whose connection was lost. The transaction is rolled back
+// by the server.
+var ErrTxLost = errors.New("connection lost in a transaction")
+
+// reconnect replaces a closed pool connection by a new one with the same statements prepared. Until the server is
+// reachable again, the received commands fail with the connect error. It returns false if the connection received
+// CommandDisconnect meanwhile.
+func (c *connection) reconnect() bool {
+	c.close()
+
+	delay := minReconnectDelay
+	for {
+		err := c.connectConfig(c.baseConfig.Copy())
+		if err == nil {
+			err = c.prepareStatements()
+			if err == nil {
+				break
+			}
+			c.close()
+		}
+
+		timer := time.NewTimer(delay)
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case cmd := <-c.commandChan:
+				if cmd.CommandType == CommandDisconnect {
+					timer.Stop()
+					c.stopFailback()
+					if cmd.Query != nil {
+						cmd.Query.ready()
+					}
+					return false
+				}
+				c.failCommand(cmd, fmt.Errorf("reconnecting: %w", err))
+			}
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+
+	c.connected()
+	c.scheduleFailback()
+	c.ready()
+	return true
+}
+
+// retire replaces the connection by a new one after the pool retired it for its max lifetime or idle time, e.g. to
+// free the memory of the backend. The new connection is established and its statements are prepared before the
+// current one is closed. If that fails, the current connection is kept. The new connection is dialed on the goroutine
+// of the connection, so its queued commands wait for the dial and the prepare; the pool sends CommandRetire only to
+// ready connections without queued commands and does not dispatch to the connection until it announces itself again.
+func (c *connection) retire() {
+	if c.pinned || c.status == statusClosed {
+		return
+	}
+
+	next := c.next()
+	if err := next.connectConfig(c.baseConfig.Copy()); err != nil {
+		return
+	}
+	if err := next.prepareStatements(); err != nil {
+		next.close()
+		return
+	}
+
+	c.swap(next)
+	c.connected()
+	c.scheduleFailback()
+}
+
+// prepareStatements prepares the statements of the lost connection again.
+func (c *connection) prepareStatements() error {
+	if len(c.statements) == 0 {
+		return nil
+	}
+
+	c.wBuf = c.wBuf[:0]
+	for name, sql := range c.statements {
+		c.wBuf = (&pgproto.Parse{Name: name, Query: sql}).Encode(c.wBuf)
+	}
+	c.wBuf = (&pgproto.Sync{}).Encode(c.wBuf)
+	if _, err := c.conn.Write(c.wBuf); err != nil {
+		return err
+	}
+
+	var parseErr error
+	for {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.ErrorResponse:
+			parseErr = ErrorResponseToPgError(msg)
+		case *pgproto.ReadyForQuery:
+			return parseErr
+		}
+	}
+}
+
+// failCommand concludes cmd with err without executing it.
+func (c *connection) failCommand(cmd Command, err error) {
+	if cmd.Unpin {
+		c.pinned = false
+		c.txLost = false
+	}
+	if cmd.Query == nil {
+		return
+	}
+
+	cmd.Query.R.concludeCommand(nil, err)
+	cmd.Query.ready()
+	if !c.txLost && c.status != statusClosed {
+		c.ready()
+	}
+}
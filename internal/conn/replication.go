@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package conn
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"pap/internal/pgproto"
+)
+
+// Replication is the body of CommandReplication. The connection executes the START_REPLICATION statement SQL and
+// delivers the data of every CopyData message received from the server to Data. The data received from Feedback is
+// sent to the server in CopyData messages. Streaming goes on until Done is closed, the server ends it or the
+// connection fails, then the connection is closed.
+//
+// The command query is released twice: when streaming starts or the statement fails with its result, and once more
+// when streaming ends with the reason, which is nil if Done was closed or the server ended streaming.
+type Replication struct {
+	SQL      string
+	Data     chan<- []byte
+	Feedback <-chan []byte
+	Done     <-chan struct{}
+}
+
+func (c *connection) replicate(q *Query, r *Replication) {
+	c.wBuf = (&pgproto.Query{String: r.SQL}).Encode(c.wBuf[:0])
+	n, err := c.conn.Write(c.wBuf)
+	if err != nil {
+		c.status = statusClosed
+		q.R.concludeCommand(nil, &writeError{err: err, safeToRetry: n == 0})
+		q.ready()
+		return
+	}
+
+	copyBoth := false
+	for !copyBoth {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			q.R.concludeCommand(nil, err)
+			q.ready()
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.CopyBothResponse:
+			copyBoth = true
+		case *pgproto.ErrorResponse:
+			q.R.concludeCommand(nil, ErrorResponseToPgError(msg))
+		case *pgproto.CommandComplete:
+			q.R.concludeCommand(nil, errors.New("statement is not START_REPLICATION"))
+		case *pgproto.ReadyForQuery:
+			q.ready()
+			return
+		}
+	}
+	q.ready()
+	c.disableReadTimeout()
+
+	// the feedback goroutine is the only writer while streaming
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		buf := make([]byte, 0, 64)
+		for {
+			select {
+			case data := <-r.Feedback:
+				buf = append(buf[:0], 'd', 0, 0, 0, 0)
+				binary.BigEndian.PutUint32(buf[1:], uint32(len(data)+4))
+				buf = append(buf, data...)
+				if _, err := c.conn.Write(buf); err != nil {
+					// the read fails too
+					return
+				}
+			case <-r.Done:
+				// interrupt the blocked read
+				_ = c.conn.SetReadDeadline(time.Now())
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	streaming := true
+	for streaming {
+		msg, err := c.receiveMessage()
+		if err != nil {
+			select {
+			case <-r.Done:
+			default:
+				q.R.err = err
+			}
+			break
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.CopyData:
+			select {
+			case r.Data <- msg.Data:
+			case <-r.Done:
+			}
+		case *pgproto.ErrorResponse:
+			q.R.err = ErrorResponseToPgError(msg)
+			streaming = false
+		case *pgproto.CopyDone:
+			streaming = false
+		}
+	}
+
+	q.ready()
+}
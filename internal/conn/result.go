@@ -5,11 +5,15 @@
 package conn
 
 import (
+	"pap/internal/cfg"
 	"pap/internal/pgtype"
 )
 
 type ResultFunc func(dest interface{}) error
 
+// ResultNoticesFunc is a ResultFunc which also returns the notices received while the query was executed.
+type ResultNoticesFunc func(dest interface{}) ([]*cfg.Notice, error)
+
 // Result is the saved query response that is returned by calling Read on a ResultReader.
 type Result struct {
 	rowValues [][]byte
@@ -18,6 +22,7 @@ type Result struct {
 	//scanPlans         []pgtype.ScanPlan
 	commandTag       CommandTag
 	commandConcluded bool
+	notices          []*cfg.Notice // notices received while the command was executed
 }
 
 func (r *Result) concludeCommand(commandTag CommandTag, err error) {
@@ -37,3 +42,18 @@ func (r *Result) concludeCommand(commandTag CommandTag, err error) {
 func (r *Result) Error() error {
 	return r.err
 }
+
+// RowValues returns the raw values of all received rows, row after row.
+func (r *Result) RowValues() [][]byte {
+	return r.rowValues
+}
+
+// Notices returns the notices received while the command was executed. The slice is reused by the next command.
+func (r *Result) Notices() []*cfg.Notice {
+	return r.notices
+}
+
+// CommandTag returns the command tag of the concluded command.
+func (r *Result) CommandTag() CommandTag {
+	return r.commandTag
+}
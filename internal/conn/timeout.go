@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrReadTimeout is wrapped by the error of a read that made no progress for the ReadTimeout of the config. Unlike
+// other timeouts it is fatal for the connection.
+var ErrReadTimeout = errors.New("read timeout")
+
+// deadlineConn sets the read and write deadlines before every read and write, so that a connection that stops
+// responding fails instead of hanging. A deadline set explicitly, e.g. for the context of Exec, is kept if it is
+// earlier.
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration // zero disables it, e.g. while waiting for notifications
+	writeTimeout time.Duration
+
+	mutex         sync.Mutex // guards the deadlines, which may be set by another goroutine to interrupt a read
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.readTimeout <= 0 {
+		return c.Conn.Read(b)
+	}
+
+	c.mutex.Lock()
+	deadline, timeout := earlier(c.readDeadline, c.readTimeout)
+	err := c.Conn.SetReadDeadline(deadline)
+	c.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	var netErr net.Error
+	if timeout && errors.As(err, &netErr) && netErr.Timeout() {
+		// not a net.Error, so that the connection is closed
+		return n, fmt.Errorf("%w: no response for %s", ErrReadTimeout, c.readTimeout)
+	}
+	return n, err
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		c.mutex.Lock()
+		deadline, _ := earlier(c.writeDeadline, c.writeTimeout)
+		err := c.Conn.SetWriteDeadline(deadline)
+		c.mutex.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeDeadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// earlier returns the earlier of deadline and now plus timeout, a zero deadline is not set. timeout reports whether
+// it is the one of timeout.
+func earlier(deadline time.Time, timeout time.Duration) (time.Time, bool) {
+	t := time.Now().Add(timeout)
+	if !deadline.IsZero() && deadline.Before(t) {
+		return deadline, false
+	}
+	return t, true
+}
+
+// disableReadTimeout disables the read timeout until the connection is closed, for connections waiting for messages
+// without a command.
+func (c *connection) disableReadTimeout() {
+	if dc, ok := c.conn.(*deadlineConn); ok {
+		dc.readTimeout = 0
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// LargeObjectMode is the access mode of an opened large object.
+type LargeObjectMode int32
+
+const (
+	LargeObjectModeWrite LargeObjectMode = 0x20000
+	LargeObjectModeRead  LargeObjectMode = 0x40000
+)
+
+// maxLargeObjectChunkLen limits the data of one loread or lowrite call.
+const maxLargeObjectChunkLen = 1 << 20
+
+// LargeObjects is the large object API of a transaction. Large objects are only accessible inside a transaction, the
+// functions are called on its connection.
+type LargeObjects struct {
+	tx *Tx
+}
+
+// LargeObjects returns the large object API of tx.
+func (tx *Tx) LargeObjects() *LargeObjects {
+	return &LargeObjects{tx: tx}
+}
+
+// Create creates a new large object. If oid is zero, the server assigns an unused oid. The oid of the created object
+// is returned.
+func (o *LargeObjects) Create(ctx context.Context, oid uint32) (uint32, error) {
+	result, err := o.call(ctx, "lo_create", oid)
+	if err != nil {
+		return 0, err
+	}
+	return result.(uint32), nil
+}
+
+// Open opens the large object oid in mode. The object is closed at the end of the transaction.
+func (o *LargeObjects) Open(ctx context.Context, oid uint32, mode LargeObjectMode) (*LargeObject, error) {
+	result, err := o.call(ctx, "lo_open", oid, int32(mode))
+	if err != nil {
+		return nil, err
+	}
+	return &LargeObject{ctx: ctx, lo: o, fd: result.(int32)}, nil
+}
+
+// Unlink removes the large object oid.
+func (o *LargeObjects) Unlink(ctx context.Context, oid uint32) error {
+	result, err := o.call(ctx, "lo_unlink", oid)
+	if err != nil {
+		return err
+	}
+	if result.(int32) != 1 {
+		return fmt.Errorf("failed to remove large object %d", oid)
+	}
+	return nil
+}
+
+func (o *LargeObjects) call(ctx context.Context, fn string, args ...interface{}) (interface{}, error) {
+	if o.tx.closed {
+		return nil, ErrTxClosed
+	}
+
+	result, err := o.tx.p.callFunction(ctx, o.tx.number, fn, args)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("%s returned NULL", fn)
+	}
+	return result, nil
+}
+
+// LargeObject is an opened large object. It implements io.Reader, io.Writer, io.Seeker and io.Closer. The context
+// passed to Open is used by all calls. A LargeObject must not be used concurrently.
+type LargeObject struct {
+	ctx context.Context
+	lo  *LargeObjects
+	fd  int32
+}
+
+// Read reads up to len(p) bytes at the current position.
+func (o *LargeObject) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		chunkLen := len(p) - n
+		if chunkLen > maxLargeObjectChunkLen {
+			chunkLen = maxLargeObjectChunkLen
+		}
+
+		result, err := o.lo.call(o.ctx, "loread", o.fd, int32(chunkLen))
+		if err != nil {
+			return n, err
+		}
+		data := result.([]byte)
+		n += copy(p[n:], data)
+		if len(data) < chunkLen {
+			break
+		}
+	}
+
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Write writes p at the current position.
+func (o *LargeObject) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		chunkLen := len(p) - n
+		if chunkLen > maxLargeObjectChunkLen {
+			chunkLen = maxLargeObjectChunkLen
+		}
+
+		result, err := o.lo.call(o.ctx, "lowrite", o.fd, p[n:n+chunkLen])
+		if err != nil {
+			return n, err
+		}
+		written := int(result.(int32))
+		if written < 0 {
+			return n, fmt.Errorf("failed to write large object")
+		}
+		n += written
+		if written < chunkLen {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// Seek moves the current position, whence is io.SeekStart, io.SeekCurrent or io.SeekEnd.
+func (o *LargeObject) Seek(offset int64, whence int) (int64, error) {
+	result, err := o.lo.call(o.ctx, "lo_lseek64", o.fd, offset, int32(whence))
+	if err != nil {
+		return 0, err
+	}
+	return result.(int64), nil
+}
+
+// Tell returns the current position.
+func (o *LargeObject) Tell() (int64, error) {
+	result, err := o.lo.call(o.ctx, "lo_tell64", o.fd)
+	if err != nil {
+		return 0, err
+	}
+	return result.(int64), nil
+}
+
+// Truncate truncates the large object to size, or extends it with zeroes.
+func (o *LargeObject) Truncate(size int64) error {
+	_, err := o.lo.call(o.ctx, "lo_truncate64", o.fd, size)
+	return err
+}
+
+// Close closes the large object.
+func (o *LargeObject) Close() error {
+	_, err := o.lo.call(o.ctx, "lo_close", o.fd)
+	return err
+}
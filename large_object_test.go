@@ -0,0 +1,282 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"pap/internal/pgtype"
+)
+
+// fakeProc is a large object function of the fake server.
+type fakeProc struct {
+	oid       uint32
+	resultOID uint32
+	argOIDs   string
+}
+
+var fakeProcs = map[string]fakeProc{
+	"lo_create":     {715, pgtype.OIDOID, "26"},
+	"lo_open":       {952, pgtype.Int4OID, "26,23"},
+	"lo_close":      {953, pgtype.Int4OID, "23"},
+	"loread":        {954, pgtype.ByteaOID, "23,23"},
+	"lowrite":       {955, pgtype.Int4OID, "23,17"},
+	"lo_unlink":     {964, pgtype.Int4OID, "26"},
+	"lo_lseek64":    {3170, pgtype.Int8OID, "23,20,23"},
+	"lo_tell64":     {3171, pgtype.Int8OID, "23"},
+	"lo_truncate64": {3172, pgtype.Int4OID, "23,20"},
+}
+
+// fakeLargeObject is an opened large object of the fake server.
+type fakeLargeObject struct {
+	oid uint32
+	pos int64
+}
+
+// largeObjectServer is a fake server storing large objects in memory. It counts the fast-path calls by function
+// name and records the lengths of the loread and lowrite calls.
+type largeObjectServer struct {
+	mutex    sync.Mutex
+	objects  map[uint32][]byte
+	fds      []*fakeLargeObject
+	calls    map[string]int
+	chunkLen []int
+}
+
+func startLargeObjectServer(t *testing.T) (*Pap, *largeObjectServer) {
+	s := &largeObjectServer{objects: make(map[uint32][]byte), calls: make(map[string]int)}
+	p := startFakePool(t, func(sql string, args [][]byte) fakeResult {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.result(sql, args)
+	})
+	return p, s
+}
+
+func (s *largeObjectServer) result(sql string, args [][]byte) fakeResult {
+	switch {
+	case sql == "begin":
+		return fakeResult{tag: "BEGIN"}
+	case sql == "commit" || sql == "rollback":
+		return fakeResult{tag: strings.ToUpper(sql)}
+	case strings.Contains(sql, "from pg_proc"):
+		r := fakeResult{params: []uint32{pgtype.TextOID}, oids: []uint32{pgtype.OIDOID, pgtype.OIDOID, pgtype.TextOID}}
+		if args == nil {
+			return r
+		}
+		if proc, ok := fakeProcs[string(args[0])]; ok {
+			r.rows = [][]interface{}{{proc.oid, proc.resultOID, proc.argOIDs}}
+		}
+		return r
+	case strings.HasPrefix(sql, "function "):
+		oid, _ := strconv.ParseUint(strings.TrimPrefix(sql, "function "), 10, 32)
+		for name, proc := range fakeProcs {
+			if proc.oid == uint32(oid) {
+				s.calls[name]++
+				return s.call(name, proc, args)
+			}
+		}
+	}
+	return fakeResult{err: "unexpected statement " + sql}
+}
+
+// call executes the function name with the binary args.
+func (s *largeObjectServer) call(name string, proc fakeProc, args [][]byte) fakeResult {
+	int4 := func(i int) int32 { return int32(binary.BigEndian.Uint32(args[i])) }
+	int8 := func(i int) int64 { return int64(binary.BigEndian.Uint64(args[i])) }
+	fd := func() *fakeLargeObject {
+		if i := int(int4(0)); i < len(s.fds) {
+			return s.fds[i]
+		}
+		return nil
+	}
+	r := fakeResult{oids: []uint32{proc.resultOID}}
+
+	switch name {
+	case "lo_create":
+		oid := binary.BigEndian.Uint32(args[0])
+		if oid == 0 {
+			oid = uint32(16384 + len(s.objects))
+		}
+		s.objects[oid] = nil
+		r.rows = [][]interface{}{{oid}}
+	case "lo_open":
+		oid := binary.BigEndian.Uint32(args[0])
+		if _, ok := s.objects[oid]; !ok {
+			return fakeResult{err: "large object " + strconv.Itoa(int(oid)) + " does not exist"}
+		}
+		s.fds = append(s.fds, &fakeLargeObject{oid: oid})
+		r.rows = [][]interface{}{{int32(len(s.fds) - 1)}}
+	case "lo_unlink":
+		oid := binary.BigEndian.Uint32(args[0])
+		if _, ok := s.objects[oid]; !ok {
+			return fakeResult{err: "large object " + strconv.Itoa(int(oid)) + " does not exist"}
+		}
+		delete(s.objects, oid)
+		r.rows = [][]interface{}{{int32(1)}}
+	case "lo_close":
+		r.rows = [][]interface{}{{int32(0)}}
+	case "loread":
+		o := fd()
+		data := s.objects[o.oid]
+		n := int64(int4(1))
+		s.chunkLen = append(s.chunkLen, int(n))
+		if o.pos+n > int64(len(data)) {
+			n = int64(len(data)) - o.pos
+		}
+		r.rows = [][]interface{}{{append([]byte{}, data[o.pos:o.pos+n]...)}}
+		o.pos += n
+	case "lowrite":
+		o := fd()
+		data := s.objects[o.oid]
+		s.chunkLen = append(s.chunkLen, len(args[1]))
+		if end := o.pos + int64(len(args[1])); end > int64(len(data)) {
+			data = append(data, make([]byte, end-int64(len(data)))...)
+		}
+		copy(data[o.pos:], args[1])
+		s.objects[o.oid] = data
+		o.pos += int64(len(args[1]))
+		r.rows = [][]interface{}{{int32(len(args[1]))}}
+	case "lo_lseek64":
+		o := fd()
+		switch int4(2) {
+		case io.SeekStart:
+			o.pos = int8(1)
+		case io.SeekCurrent:
+			o.pos += int8(1)
+		case io.SeekEnd:
+			o.pos = int64(len(s.objects[o.oid])) + int8(1)
+		}
+		r.rows = [][]interface{}{{o.pos}}
+	case "lo_tell64":
+		r.rows = [][]interface{}{{fd().pos}}
+	case "lo_truncate64":
+		o := fd()
+		data := s.objects[o.oid]
+		if size := int8(1); size < int64(len(data)) {
+			data = data[:size]
+		} else {
+			data = append(data, make([]byte, size-int64(len(data)))...)
+		}
+		s.objects[o.oid] = data
+		r.rows = [][]interface{}{{int32(0)}}
+	}
+	return r
+}
+
+// resetChunks returns and clears the recorded lengths of the loread and lowrite calls.
+func (s *largeObjectServer) resetChunks() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	chunkLen := s.chunkLen
+	s.chunkLen = nil
+	return chunkLen
+}
+
+func TestLargeObjects(t *testing.T) {
+	p, s := startLargeObjectServer(t)
+	ctx := context.Background()
+
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	los := tx.LargeObjects()
+
+	oid, err := los.Create(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oid != 16384 {
+		t.Errorf("expected the oid 16384, got %d", oid)
+	}
+	if oid, err := los.Create(ctx, 20000); err != nil || oid != 20000 {
+		t.Errorf("expected the oid 20000, got %d, %v", oid, err)
+	}
+
+	lo, err := los.Open(ctx, oid, LargeObjectModeRead|LargeObjectModeWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the data of Write and Read is split into chunks of maxLargeObjectChunkLen
+	data := bytes.Repeat([]byte("0123456789"), maxLargeObjectChunkLen/4)
+	if n, err := lo.Write(data); err != nil || n != len(data) {
+		t.Fatalf("expected %d bytes written, got %d, %v", len(data), n, err)
+	}
+	expected := []int{maxLargeObjectChunkLen, maxLargeObjectChunkLen, len(data) - 2*maxLargeObjectChunkLen}
+	if chunkLen := s.resetChunks(); !reflect.DeepEqual(chunkLen, expected) {
+		t.Errorf("expected lowrite chunks %v, got %v", expected, chunkLen)
+	}
+
+	if pos, err := lo.Tell(); err != nil || pos != int64(len(data)) {
+		t.Errorf("expected position %d, got %d, %v", len(data), pos, err)
+	}
+	if pos, err := lo.Seek(0, io.SeekStart); err != nil || pos != 0 {
+		t.Errorf("expected position 0, got %d, %v", pos, err)
+	}
+
+	buf := make([]byte, len(data)+10)
+	n, err := lo.Read(buf)
+	if err != nil || n != len(data) || !bytes.Equal(buf[:n], data) {
+		t.Fatalf("expected the written data, got %d bytes, %v", n, err)
+	}
+	expected = []int{maxLargeObjectChunkLen, maxLargeObjectChunkLen, len(buf) - 2*maxLargeObjectChunkLen}
+	if chunkLen := s.resetChunks(); !reflect.DeepEqual(chunkLen, expected) {
+		t.Errorf("expected loread chunks %v, got %v", expected, chunkLen)
+	}
+	if n, err := lo.Read(buf); err != io.EOF || n != 0 {
+		t.Errorf("expected io.EOF, got %d, %v", n, err)
+	}
+
+	if err := lo.Truncate(5); err != nil {
+		t.Fatal(err)
+	}
+	if pos, err := lo.Seek(-2, io.SeekEnd); err != nil || pos != 3 {
+		t.Errorf("expected position 3, got %d, %v", pos, err)
+	}
+	if n, err := lo.Read(buf); err != nil || string(buf[:n]) != "34" {
+		t.Errorf("expected 34, got %q, %v", buf[:n], err)
+	}
+	if err := lo.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := los.Unlink(ctx, oid); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server errors are returned
+	if _, err := los.Open(ctx, oid, LargeObjectModeRead); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected error for the removed large object, got %v", err)
+	}
+	if err := los.Unlink(ctx, oid); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected error for the removed large object, got %v", err)
+	}
+
+	s.mutex.Lock()
+	for name := range fakeProcs {
+		if s.calls[name] == 0 {
+			t.Errorf("%s was not called", name)
+		}
+	}
+	s.mutex.Unlock()
+
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := los.Create(ctx, 0); err != ErrTxClosed {
+		t.Errorf("expected ErrTxClosed, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"time"
+
+	"pap/internal/cfg"
+)
+
+const defaultLatencyCheckInterval = 5 * time.Second
+
+// measureLatency measures the round trip time of every host of config for load_balance_hosts=latency until done is
+// closed. An unreachable host gets a negative latency.
+func measureLatency(config *cfg.Config, done <-chan struct{}) {
+	interval := config.LatencyCheckInterval
+	if interval <= 0 {
+		interval = defaultLatencyCheckInterval
+	}
+
+	for _, hostConfig := range config.HostConfigs() {
+		host := &cfg.FallbackConfig{Host: hostConfig.Host, Port: hostConfig.Port}
+		go runChecks(done, config.ConnInfo, hostConfig, interval, ping, func(latency time.Duration, err error) {
+			if err != nil {
+				latency = -1
+			}
+			config.Balancer.SetLatency(host, latency)
+		})
+	}
+}
+
+func ping(cc *checkConn) (time.Duration, error) {
+	start := time.Now()
+	if _, err := cc.query("select 1"); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
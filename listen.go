@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pap/internal/conn"
+)
+
+const (
+	listenBufferLen       = 64
+	listenReconnectMin    = 100 * time.Millisecond
+	listenReconnectMax    = 10 * time.Second
+	listenNumberFirstConn = max
+)
+
+var ErrNoChannels = errors.New("no channels to listen")
+
+// Notification is a message received from the PostgreSQL LISTEN/NOTIFY system, or a possible gap event when Gap is
+// set.
+type Notification = conn.Notification
+
+// Subscription delivers the notifications of the channels passed to Listen. It owns a dedicated connection, which is
+// restored with LISTEN for the same channels after a failure.
+type Subscription struct {
+	p        *Pap
+	number   int
+	channels []string
+
+	notifications chan Notification
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+var listenNumber int64
+
+// Listen starts listening to channels on a dedicated connection. The notifications are delivered by
+// Subscription.Notifications until ctx is done or the subscription is closed.
+func (p *Pap) Listen(ctx context.Context, channels ...string) (*Subscription, error) {
+	if len(channels) == 0 {
+		return nil, ErrNoChannels
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s := &Subscription{
+		p:             p,
+		number:        listenNumberFirstConn + int(atomic.AddInt64(&listenNumber, 1)-1),
+		channels:      channels,
+		notifications: make(chan Notification, listenBufferLen),
+		done:          make(chan struct{}),
+	}
+
+	end, err := s.listen(false)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
+	go s.run(end)
+
+	return s, nil
+}
+
+// Notifications returns the channel of received notifications. It is closed after the subscription is closed.
+func (s *Subscription) Notifications() <-chan Notification {
+	return s.notifications
+}
+
+// Close stops listening and closes the connection of the subscription.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// listen connects a new connection and starts listening on it. The returned channel receives the reason listening
+// ended. gap is set when listening is restored, the connection emits the gap event before delivering notifications.
+func (s *Subscription) listen(gap bool) (<-chan error, error) {
+	commandChan := make(chan conn.Command, 2)
+	conn.Start(s.number, commandChan, make(chan int, 1), nil, nil)
+
+	q := conn.NewQuery(s.p.config.ConnInfo, nil)
+	q.Mutex.Lock()
+	commandChan <- conn.Command{
+		CommandType: conn.CommandConnect,
+		Query:       q,
+		Body:        s.p.config.Copy(),
+	}
+	q.Mutex.Lock()
+	if err := q.R.Error(); err != nil {
+		q.Mutex.Unlock()
+		commandChan <- conn.Command{CommandType: conn.CommandDisconnect}
+		return nil, err
+	}
+
+	end := make(chan error, 1)
+	commandChan <- conn.Command{
+		CommandType: conn.CommandListen,
+		Query:       q,
+		Body: &conn.Listen{
+			Channels:      s.channels,
+			Notifications: s.notifications,
+			Done:          s.done,
+			End:           end,
+			Gap:           gap,
+		},
+	}
+	q.Mutex.Lock()
+	err := q.R.Error()
+	q.Mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return end, nil
+}
+
+// run waits for the end of listening and restores it until the subscription is closed.
+func (s *Subscription) run(end <-chan error) {
+	defer close(s.notifications)
+
+	for {
+		<-end
+
+		var err error
+		delay := listenReconnectMin
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-time.After(delay):
+			}
+
+			end, err = s.listen(true)
+			if err == nil {
+				break
+			}
+
+			delay *= 2
+			if delay > listenReconnectMax {
+				delay = listenReconnectMax
+			}
+		}
+	}
+}
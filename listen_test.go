@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pap/internal/pgproto"
+)
+
+// listenServer answers LISTEN with the notifications of notify, the connection is closed after them. Other queries
+// get an empty result.
+func listenServer(t *testing.T, notify func(n int32) []string) *Pap {
+	var n int32
+	addr := fakeServer(t, nil, func(b *pgproto.Backend) {
+		for {
+			msg, err := b.Receive()
+			if err != nil {
+				return
+			}
+			q, ok := msg.(*pgproto.Query)
+			if !ok {
+				continue
+			}
+			if !strings.HasPrefix(q.String, "listen ") {
+				b.Send(&pgproto.CommandComplete{CommandTag: []byte("SELECT 0")})
+				b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+				continue
+			}
+
+			b.Send(&pgproto.CommandComplete{CommandTag: []byte("LISTEN")})
+			b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+			for _, payload := range notify(atomic.AddInt32(&n, 1)) {
+				b.Send(&pgproto.NotificationResponse{PID: 7, Channel: "events", Payload: payload})
+			}
+			return
+		}
+	})
+
+	config, err := ParseConfig("postgres://u@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := StartConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func receiveNotification(t *testing.T, s *Subscription) Notification {
+	t.Helper()
+	select {
+	case n, ok := <-s.Notifications():
+		if !ok {
+			t.Fatal("notifications closed")
+		}
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification")
+	}
+	return Notification{}
+}
+
+func TestListen(t *testing.T) {
+	p := listenServer(t, func(n int32) []string {
+		if n == 1 {
+			return []string{"a", "b"}
+		}
+		return []string{"c"}
+	})
+
+	if _, err := p.Listen(context.Background()); err != ErrNoChannels {
+		t.Errorf("expected ErrNoChannels, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := p.Listen(ctx, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range []string{"a", "b"} {
+		if n := receiveNotification(t, s); n != (Notification{PID: 7, Channel: "events", Payload: payload}) {
+			t.Errorf("unexpected notification: %+v", n)
+		}
+	}
+
+	// the server closed the connection, listening is restored with the gap event first
+	if n := receiveNotification(t, s); n != (Notification{Gap: true}) {
+		t.Errorf("expected the gap event, got %+v", n)
+	}
+	if n := receiveNotification(t, s); n.Payload != "c" || n.Gap {
+		t.Errorf("unexpected notification after the gap: %+v", n)
+	}
+
+	cancel()
+	for {
+		select {
+		case _, ok := <-s.Notifications():
+			if !ok {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("notifications not closed after ctx is done")
+		}
+	}
+}
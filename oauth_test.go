@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"pap/internal/pgproto"
+)
+
+// oauthServer accepts connections authenticated with the OAUTHBEARER token and rejects other tokens like
+// PostgreSQL does.
+func oauthServer(t *testing.T, token string) string {
+	return fakeServer(t, func(b *pgproto.Backend) bool {
+		b.Send(&pgproto.AuthenticationSASL{AuthMechanisms: []string{"OAUTHBEARER"}})
+		b.SetAuthType(pgproto.AuthTypeSASL)
+		msg, err := b.Receive()
+		if err != nil {
+			return false
+		}
+		initial, ok := msg.(*pgproto.SASLInitialResponse)
+		if !ok || initial.AuthMechanism != "OAUTHBEARER" {
+			return false
+		}
+
+		if !bytes.Equal(initial.Data, []byte("n,,\x01auth=Bearer "+token+"\x01\x01")) {
+			b.Send(&pgproto.AuthenticationSASLContinue{Data: []byte(`{"status":"invalid_token","scope":"db"}`)})
+			b.SetAuthType(pgproto.AuthTypeSASLContinue)
+			if msg, err := b.Receive(); err != nil || !bytes.Equal(msg.(*pgproto.SASLResponse).Data, []byte{1}) {
+				return false
+			}
+			b.Send(&pgproto.ErrorResponse{Severity: "FATAL", Code: "28000", Message: "OAuth bearer authentication failed"})
+			return false
+		}
+		return true
+	}, nil)
+}
+
+func TestOAuthBearer(t *testing.T) {
+	addr := oauthServer(t, "fresh")
+	config, err := ParseConfig("postgres://u@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []OAuthTokenRequest
+	config.OAuthTokenProvider = func(_ context.Context, req OAuthTokenRequest) (string, error) {
+		requests = append(requests, req)
+		if req.Failure != nil {
+			return "fresh", nil
+		}
+		return "expired", nil
+	}
+
+	cc, err := connectCheck(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.close()
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 token requests, got %d", len(requests))
+	}
+	if requests[0].Failure != nil || requests[0].User != "u" || requests[0].Host != "127.0.0.1" {
+		t.Errorf("unexpected first request: %+v", requests[0])
+	}
+	if failure := requests[1].Failure; failure == nil || failure.Status != "invalid_token" || failure.Scope != "db" {
+		t.Errorf("unexpected failure of the retry: %+v", failure)
+	}
+
+	config.OAuthTokenProvider = func(context.Context, OAuthTokenRequest) (string, error) {
+		return "wrong", nil
+	}
+	if _, err := connectCheck(nil, config); err == nil {
+		t.Error("expected error for a rejected token")
+	}
+
+	config.OAuthTokenProvider = nil
+	if _, err := connectCheck(nil, config); err == nil {
+		t.Error("expected error without OAuthTokenProvider")
+	}
+}
@@ -5,6 +5,8 @@
 package pap
 
 import (
+	"sync"
+
 	"pap/internal/cfg"
 	"pap/internal/conn"
 )
@@ -21,9 +23,17 @@ type Pap struct {
 	conns   *connections
 	queries *Queries
 
-	queryChan      chan *conn.Query
+	queryChan      chan queuedQuery
 	emptyQueryChan chan *conn.Query
 	connReadyChan  chan int
+	queued         int64 // the queries waiting for a connection, accessed atomically
+
+	ps        preparedStatements
+	functions functions
+
+	replica *Pap    // the replica pool, nil without replicas
+	health  *health // the health of the replicas, set on the replica pool
 
-	ps preparedStatements
+	done      chan struct{} // closed by Close, stops the background goroutines of the pool
+	closeOnce sync.Once
 }
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pap/internal/conn"
+	"pap/internal/pgproto"
+)
+
+// passwordServer accepts connections authenticated with the cleartext password returned by password.
+func passwordServer(t *testing.T, password func() string) string {
+	return fakeServer(t, func(b *pgproto.Backend) bool {
+		b.Send(&pgproto.AuthenticationCleartextPassword{})
+		b.SetAuthType(pgproto.AuthTypeCleartextPassword)
+		msg, err := b.Receive()
+		if err != nil {
+			return false
+		}
+		if msg.(*pgproto.PasswordMessage).Password != password() {
+			b.Send(&pgproto.ErrorResponse{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"})
+			return false
+		}
+		return true
+	}, nil)
+}
+
+func TestPasswordProvider(t *testing.T) {
+	var rotation int32
+	secret := func() string {
+		return []string{"first", "second"}[atomic.LoadInt32(&rotation)]
+	}
+	addr := passwordServer(t, secret)
+
+	config, err := ParseConfig("postgres://u:stale@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	config.PasswordProvider = func(_ context.Context, host, user string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		if host != "127.0.0.1" || user != "u" {
+			t.Errorf("unexpected host %q and user %q", host, user)
+		}
+		return secret(), nil
+	}
+
+	first, err := connectCheck(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.close()
+
+	atomic.StoreInt32(&rotation, 1)
+	second, err := connectCheck(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.close()
+
+	if calls != 2 {
+		t.Errorf("expected 2 calls of PasswordProvider, got %d", calls)
+	}
+
+	config.PasswordProvider = nil
+	if _, err := connectCheck(nil, config); err == nil {
+		t.Error("expected error for the stale password")
+	}
+}
+
+// TestRequireAuth checks that require_auth=scram-sha-256 refuses the other authentication requests before the
+// password is sent.
+func TestRequireAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  pgproto.BackendMessage
+		authType uint32
+	}{
+		{"cleartext", &pgproto.AuthenticationCleartextPassword{}, pgproto.AuthTypeCleartextPassword},
+		{"md5", &pgproto.AuthenticationMD5Password{Salt: [4]byte{1, 2, 3, 4}}, pgproto.AuthTypeMD5Password},
+		{"trust", nil, 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			passwordSent := make(chan pgproto.FrontendMessage, 1)
+			addr := fakeServer(t, func(b *pgproto.Backend) bool {
+				if tt.request == nil {
+					return true
+				}
+				b.Send(tt.request)
+				b.SetAuthType(tt.authType)
+				if msg, err := b.Receive(); err == nil {
+					passwordSent <- msg
+				}
+				return false
+			}, nil)
+
+			config, err := ParseConfig("postgres://u:secret@" + addr + "/db?sslmode=disable&require_auth=scram-sha-256")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := connectCheck(nil, config); !errors.Is(err, conn.ErrAuthNotAllowed) {
+				t.Fatalf("expected ErrAuthNotAllowed, got %v", err)
+			}
+			select {
+			case msg := <-passwordSent:
+				t.Errorf("password sent: %T", msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@
 package pap
 
 import (
+	"context"
 	"strconv"
 	"sync"
 
@@ -22,7 +23,9 @@ type preparedStatements struct {
 	mutex sync.RWMutex
 }
 
-func (p *Pap) checkDescription(query *conn.Query) (*conn.Description, error) {
+// checkDescription returns the description of the prepared statement of query, the statement is prepared if it is
+// new. ctx and try limit the waiting for a connection, see prepare.
+func (p *Pap) checkDescription(ctx context.Context, try bool, query *conn.Query) (*conn.Description, error) {
 	p.ps.mutex.RLock()
 	desc, ok := p.ps.list[query.SQL]
 
@@ -34,7 +37,7 @@ func (p *Pap) checkDescription(query *conn.Query) (*conn.Description, error) {
 		p.ps.mutex.Lock()
 		defer p.ps.mutex.Unlock()
 		query.D = &conn.Description{Name: "pap_ps_" + strconv.Itoa(len(p.ps.list))}
-		err := p.prepare(query)
+		err := p.prepare(ctx, try, query)
 		if err != nil {
 			return nil, err
 		}
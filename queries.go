@@ -18,15 +18,16 @@ type Queries struct {
 	mutex          sync.Mutex
 	ticker         *time.Ticker
 	emptyQueryChan chan *conn.Query
+	connInfo       *pgtype.ConnInfo
 	list           []*conn.Query
 }
 
-func NewQueries(count int, emptyQueryChan chan *conn.Query) *Queries {
+func NewQueries(count int, emptyQueryChan chan *conn.Query, connInfo *pgtype.ConnInfo) *Queries {
 	var q Queries
-	cInfo := pgtype.NewConnInfo()
+	q.connInfo = connInfo
 	q.list = make([]*conn.Query, count)
 	for i := range q.list {
-		q.list[i] = conn.NewQuery(cInfo, emptyQueryChan)
+		q.list[i] = conn.NewQuery(q.connInfo, emptyQueryChan)
 	}
 	q.ticker = time.NewTicker(time.Duration(conn.MaxResultSaveDurationInNanoseconds))
 	go q.startQueries()
@@ -5,6 +5,7 @@
 package pap
 
 import (
+	"context"
 	"errors"
 
 	"pap/internal/conn"
@@ -13,54 +14,139 @@ import (
 var ErrResultNotActual = errors.New("result not actual")
 var ErrArgsLimit = errors.New("args limit")
 
+// QueryAsync sends the query to the pool and returns the function to scan its result. It waits until the query is
+// queued, limited by the AcquireTimeout of the config.
 func (p *Pap) QueryAsync(sql string, args ...interface{}) conn.ResultFunc {
-	if !checkArgs(len(args)) {
-		return func(dest interface{}) error {
-			return ErrArgsLimit
-		}
-	}
-	eq := <-p.emptyQueryChan
-	eq.Mutex.Lock()
-	err := eq.Start(
-		sql,
-		args...,
-	)
+	return p.queryAsync(context.Background(), false, sql, args)
+}
+
+// QueryAsyncContext is QueryAsync which stops waiting for a connection when ctx is done, the result function then
+// returns the error of ctx. ctx is not used while the query is executed.
+func (p *Pap) QueryAsyncContext(ctx context.Context, sql string, args ...interface{}) conn.ResultFunc {
+	return p.queryAsync(ctx, false, sql, args)
+}
+
+// TryQueryAsync is QueryAsync which does not wait, the result function returns ErrPoolExhausted if all queries of
+// the pool are in use or its queue is full. It is meant for shedding load instead of piling up waiting goroutines.
+func (p *Pap) TryQueryAsync(sql string, args ...interface{}) conn.ResultFunc {
+	return p.queryAsync(context.Background(), true, sql, args)
+}
+
+func (p *Pap) queryAsync(ctx context.Context, try bool, sql string, args []interface{}) conn.ResultFunc {
+	eq, err := p.sendQuery(ctx, try, sql, args...)
 	if err != nil {
 		return func(dest interface{}) error {
 			return err
 		}
 	}
 
-	eq.D, err = p.checkDescription(eq)
-
-	if err != nil {
-		return func(dest interface{}) error {
+	return func(dest interface{}) error {
+		eq.Mutex.Lock()
+		defer eq.Close()
+		if !eq.Actual() {
+			return ErrResultNotActual
+		}
+		err := eq.Scan(dest)
+		if err != nil {
 			return err
 		}
+		return nil
 	}
+}
 
-	for i := range eq.Args {
-		err = eq.AppendParam(i)
-		if err != nil {
-			return func(dest interface{}) error {
-				return err
-			}
+// QueryAsyncNotices is QueryAsync which also returns the notices raised while the query was executed, e.g. by
+// RAISE NOTICE in a called function.
+func (p *Pap) QueryAsyncNotices(sql string, args ...interface{}) conn.ResultNoticesFunc {
+	eq, err := p.sendQuery(context.Background(), false, sql, args...)
+	if err != nil {
+		return func(dest interface{}) ([]*Notice, error) {
+			return nil, err
 		}
 	}
 
-	p.queryChan <- eq
-	return func(dest interface{}) error {
+	return func(dest interface{}) ([]*Notice, error) {
 		eq.Mutex.Lock()
 		defer eq.Close()
 		if !eq.Actual() {
-			return ErrResultNotActual
+			return nil, ErrResultNotActual
+		}
+		var notices []*Notice
+		if len(eq.R.Notices()) > 0 {
+			notices = make([]*Notice, len(eq.R.Notices()))
+			copy(notices, eq.R.Notices())
 		}
 		err := eq.Scan(dest)
 		if err != nil {
-			return err
+			return notices, err
 		}
-		return nil
+		return notices, nil
+	}
+}
+
+// sendQuery prepares the query if needed and passes it to the dispatcher. The returned query is released by the
+// connection after execution. ctx limits the waiting for a connection, if try is set the query is sent to a ready
+// connection directly and fails with ErrPoolExhausted instead of waiting.
+func (p *Pap) sendQuery(ctx context.Context, try bool, sql string, args ...interface{}) (*conn.Query, error) {
+	if !checkArgs(len(args)) {
+		return nil, ErrArgsLimit
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !p.enqueue() {
+		return nil, ErrPoolExhausted
+	}
+
+	ctx, cancel := p.acquireContext(ctx)
+	eq, err := p.startQuery(ctx, try, sql, args...)
+	if err != nil {
+		cancel()
+		p.dequeue()
+		return nil, err
 	}
+	fail := func(err error) (*conn.Query, error) {
+		eq.Close()
+		cancel()
+		p.dequeue()
+		return nil, err
+	}
+
+	eq.D, err = p.checkDescription(ctx, try, eq)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	for i := range eq.Args {
+		err = eq.AppendParam(i)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if try {
+		_, err := p.dispatchQuery(ctx, true, conn.Command{
+			CommandType: conn.CommandPreparedQuery,
+			Query:       eq,
+		})
+		cancel()
+		p.dequeue()
+		if err != nil {
+			return nil, err
+		}
+		return eq, nil
+	}
+
+	qq := queuedQuery{q: eq, ctx: ctx, cancel: cancel, claimed: new(int32)}
+	select {
+	case p.queryChan <- qq:
+	case <-ctx.Done():
+		return fail(ctx.Err())
+	}
+	if ctx.Done() != nil {
+		go qq.watch()
+	}
+	return eq, nil
 }
 
 func checkArgs(len int) bool {
@@ -71,50 +157,46 @@ func checkArgs(len int) bool {
 	return true
 }
 
-func (p *Pap) prepare(query *conn.Query) error {
-	eq := <-p.emptyQueryChan
-	eq.Mutex.Lock()
-	eq.D = query.D
-	err := eq.Start(
-		query.SQL,
-	)
-
+// prepare prepares the statement of query on a ready connection and then on the other online connections. ctx limits
+// the waiting for a connection, if try is set ErrPoolExhausted is returned instead of waiting.
+func (p *Pap) prepare(ctx context.Context, try bool, query *conn.Query) error {
+	eq, err := p.startQuery(ctx, try, query.SQL)
 	if err != nil {
 		return err
 	}
-	cr := <-p.connReadyChan
-	p.conns.list[cr].commandChan <- conn.Command{
+	eq.D = query.D
+	cr, err := p.dispatchQuery(ctx, try, conn.Command{
 		CommandType: conn.CommandPrepare,
 		Query:       eq,
+	})
+	if err != nil {
+		return err
 	}
 
-	p.conns.mutex.RLock()
-	for i := range p.conns.list {
-		if p.conns.list[i].status == connStatusOnline && i != cr {
-			eqq := <-p.emptyQueryChan
-			eqq.Mutex.Lock()
-			eqq.D = query.D
-			err = eqq.Start(
-				query.SQL,
-			)
-			if err != nil {
-				return err
-			}
-
-			p.conns.list[i].commandChan <- conn.Command{
-				CommandType: conn.CommandPrepareAsync,
-				Query:       eqq,
-			}
-		}
-	}
-	p.conns.mutex.RUnlock()
 	eq.Mutex.Lock()
 	defer eq.Close()
 	if !eq.Actual() {
 		// TODO THINK
 		return ErrResultNotActual
 	}
+	if err := eq.R.Error(); err != nil {
+		return err
+	}
 	eq.AppendResultFormat()
+
+	// the other connections prepare the statement once its description is complete, before it is cached
+	pa := &conn.PrepareAsync{SQL: query.SQL, D: query.D}
+	p.conns.mutex.RLock()
+	for i := range p.conns.list {
+		if p.conns.list[i].status == connStatusOnline && i != cr {
+			p.conns.list[i].sendAny(conn.Command{
+				CommandType: conn.CommandPrepareAsync,
+				Body:        pa,
+			})
+		}
+	}
+	p.conns.mutex.RUnlock()
+
 	p.ps.list[query.SQL] = eq.D
-	return eq.R.Error()
+	return nil
 }
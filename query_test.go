@@ -6,7 +6,10 @@ package pap
 
 import (
 	"fmt"
+	"sync"
 	"testing"
+
+	"pap/internal/pgtype"
 )
 
 func Test_checkArgs(t *testing.T) {
@@ -17,3 +20,48 @@ func Test_checkArgs(t *testing.T) {
 	}
 
 }
+
+func TestNotices(t *testing.T) {
+	config := fakePoolConfig(t, func(sql string, args [][]byte) fakeResult {
+		return fakeResult{
+			oids:    []uint32{pgtype.Int4OID},
+			rows:    [][]interface{}{{int32(1)}},
+			notices: []string{"first", "second"},
+		}
+	})
+
+	var mutex sync.Mutex
+	var received []string
+	config.OnNotice = func(number int, pid uint32, notice *Notice) {
+		mutex.Lock()
+		received = append(received, notice.Message)
+		mutex.Unlock()
+	}
+	p, err := StartConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []struct {
+		N int32
+	}
+	notices, err := p.QueryAsyncNotices("select 1")(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].N != 1 {
+		t.Errorf("unexpected result %v", rows)
+	}
+	if len(notices) != 2 || notices[0].Message != "first" || notices[1].Message != "second" || notices[0].Severity != "NOTICE" {
+		t.Errorf("unexpected notices of the query: %v", notices)
+	}
+	if notices, err := p.QueryAsyncNotices("select 1")(&rows); err != nil || len(notices) != 2 {
+		t.Errorf("expected the notices of the second query only, got %v, %v", notices, err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != 4 || received[0] != "first" || received[1] != "second" {
+		t.Errorf("unexpected notices passed to OnNotice: %v", received)
+	}
+}
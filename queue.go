@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"pap/internal/conn"
+)
+
+// ErrPoolExhausted is returned if a query cannot be queued because the queue of the pool is full, or by
+// TryQueryAsync if it would have to wait.
+var ErrPoolExhausted = errors.New("pool exhausted")
+
+// ErrPoolClosed is returned for commands waiting for a connection of a closed pool.
+var ErrPoolClosed = errors.New("pool closed")
+
+// queuedQuery is a query waiting in the dispatcher for a ready connection with the context of its caller.
+type queuedQuery struct {
+	q      *conn.Query
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// claimed is set by the dispatcher when it dispatches the query or by the watcher of ctx when it fails the query,
+	// whichever is first. The query is not touched by the other one, it may be reused meanwhile.
+	claimed *int32
+}
+
+// claim reports whether the caller concludes qq.
+func (qq queuedQuery) claim() bool {
+	return atomic.CompareAndSwapInt32(qq.claimed, 0, 1)
+}
+
+// watch fails qq when its context is done before the dispatcher takes it, so a query waiting behind another one does
+// not wait beyond its context. The dispatcher cancels the context of qq when it is done with it.
+func (qq queuedQuery) watch() {
+	<-qq.ctx.Done()
+	if qq.claim() {
+		qq.q.Fail(qq.ctx.Err())
+	}
+}
+
+// enqueue counts a query waiting for a connection. It returns false if the queue is full.
+func (p *Pap) enqueue() bool {
+	if n := atomic.AddInt64(&p.queued, 1); p.config.MaxQueueLength > 0 && n > int64(p.config.MaxQueueLength) {
+		atomic.AddInt64(&p.queued, -1)
+		return false
+	}
+	return true
+}
+
+// dequeue counts a query that got a connection or failed.
+func (p *Pap) dequeue() {
+	atomic.AddInt64(&p.queued, -1)
+}
+
+// acquireContext returns ctx limited by the AcquireTimeout of the config. ctx is returned as is if it is never done
+// and there is no AcquireTimeout.
+func (p *Pap) acquireContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.config.AcquireTimeout > 0 {
+		return context.WithTimeout(ctx, p.config.AcquireTimeout)
+	}
+	if ctx.Done() == nil {
+		return ctx, func() {}
+	}
+	return context.WithCancel(ctx)
+}
+
+// acquire counts a command dispatched by the caller instead of the dispatcher as queued, and returns ctx limited by
+// the AcquireTimeout of the config. release is called once the command is dispatched or failed.
+func (p *Pap) acquire(ctx context.Context) (_ context.Context, release func(), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if !p.enqueue() {
+		return nil, nil, ErrPoolExhausted
+	}
+	ctx, cancel := p.acquireContext(ctx)
+	return ctx, func() {
+		cancel()
+		p.dequeue()
+	}, nil
+}
+
+// acquireQuery returns an empty query. If try is set, it returns ErrPoolExhausted instead of waiting.
+func (p *Pap) acquireQuery(ctx context.Context, try bool) (*conn.Query, error) {
+	if try {
+		select {
+		case eq := <-p.emptyQueryChan:
+			return eq, nil
+		default:
+			return nil, ErrPoolExhausted
+		}
+	}
+
+	select {
+	case eq := <-p.emptyQueryChan:
+		return eq, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// startQuery returns an empty query started with sql and args. The query is locked until its result is ready. If
+// try is set, it returns ErrPoolExhausted instead of waiting.
+func (p *Pap) startQuery(ctx context.Context, try bool, sql string, args ...interface{}) (*conn.Query, error) {
+	eq, err := p.acquireQuery(ctx, try)
+	if err != nil {
+		return nil, err
+	}
+	eq.Mutex.Lock()
+	if err := eq.Start(sql, args...); err != nil {
+		eq.Close()
+		return nil, err
+	}
+	return eq, nil
+}
+
+// dispatchQuery sends cmd to a ready connection and returns its number. The query of cmd is released if that fails.
+// If try is set, it returns ErrPoolExhausted instead of waiting.
+func (p *Pap) dispatchQuery(ctx context.Context, try bool, cmd conn.Command) (int, error) {
+	var cr int
+	var err error
+	if try {
+		cr, err = p.tryDispatch(cmd)
+	} else {
+		cr, err = p.dispatch(ctx, cmd)
+	}
+	if err != nil {
+		cmd.Query.Close()
+		return 0, err
+	}
+	return cr, nil
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pap/internal/cfg"
+	"pap/internal/conn"
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+func TestQueueLimits(t *testing.T) {
+	p := &Pap{
+		config:         cfg.Config{MaxQueueLength: 1, AcquireTimeout: 10 * time.Millisecond},
+		emptyQueryChan: make(chan *conn.Query),
+	}
+
+	var dest []int
+	if err := p.TryQueryAsync("select 1")(&dest); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("TryQueryAsync: expected ErrPoolExhausted, got %v", err)
+	}
+	if err := p.QueryAsync("select 1")(&dest); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("QueryAsync: expected the acquire timeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.config.AcquireTimeout = 0
+	if err := p.QueryAsyncContext(ctx, "select 1")(&dest); !errors.Is(err, context.Canceled) {
+		t.Errorf("QueryAsyncContext: expected context.Canceled, got %v", err)
+	}
+
+	p.queued = 1
+	if err := p.QueryAsync("select 1")(&dest); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("QueryAsync: expected ErrPoolExhausted for a full queue, got %v", err)
+	}
+	if p.queued != 1 {
+		t.Errorf("expected 1 queued query, got %d", p.queued)
+	}
+}
+
+func TestDispatchTimeout(t *testing.T) {
+	p := &Pap{connReadyChan: make(chan int)}
+	qChan := make(chan queuedQuery, 1)
+	go p.start(qChan)
+
+	q := conn.NewQuery(nil, make(chan *conn.Query, 1))
+	q.Mutex.Lock()
+	if err := q.Start("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	p.queued = 1
+	qChan <- queuedQuery{q: q, ctx: ctx, cancel: cancel, claimed: new(int32)}
+
+	// no connection is ready, the query fails when its context is done
+	q.Mutex.Lock()
+	defer q.Close()
+	if err := q.R.Error(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if p.queued != 0 {
+		t.Errorf("expected no queued query, got %d", p.queued)
+	}
+}
+
+// queueTestQuery returns a started query for the tests of the dispatcher.
+func queueTestQuery(t *testing.T) *conn.Query {
+	q := conn.NewQuery(nil, make(chan *conn.Query, 1))
+	q.Mutex.Lock()
+	if err := q.Start("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// TestDispatchExpiredBehind checks that a query waiting behind another one fails when its context is done, and that
+// the connection ready later goes to the query in front.
+func TestDispatchExpiredBehind(t *testing.T) {
+	p := &Pap{
+		connReadyChan: make(chan int, 1),
+		conns:         &connections{list: []connection{{commandChan: make(chan conn.Command, 1)}}},
+	}
+	qChan := make(chan queuedQuery, 2)
+	go p.start(qChan)
+
+	first := queueTestQuery(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	qq := queuedQuery{q: first, ctx: ctx, cancel: cancel, claimed: new(int32)}
+	go qq.watch()
+
+	second := queueTestQuery(t)
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	qq2 := queuedQuery{q: second, ctx: ctx2, cancel: cancel2, claimed: new(int32)}
+	go qq2.watch()
+
+	p.queued = 2
+	qChan <- qq
+	qChan <- qq2
+
+	done := make(chan struct{})
+	go func() {
+		second.Mutex.Lock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query behind is not failed by its context")
+	}
+	if err := second.R.Error(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	p.connReadyChan <- 0
+	select {
+	case cmd := <-p.conns.list[0].commandChan:
+		if cmd.Query != first {
+			t.Error("expected the query in front")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query in front is not dispatched")
+	}
+}
+
+// TestTryQueryAsyncBusy checks that TryQueryAsync does not wait for a connection.
+func TestTryQueryAsyncBusy(t *testing.T) {
+	p := &Pap{
+		emptyQueryChan: make(chan *conn.Query, 1),
+		connReadyChan:  make(chan int, 1),
+		ps:             preparedStatements{list: map[string]*conn.Description{"select 1": {Name: "pap_ps_0"}}},
+	}
+	p.emptyQueryChan <- conn.NewQuery(nil, p.emptyQueryChan)
+	qChan := make(chan queuedQuery, 1)
+	p.queryChan = qChan
+	go p.start(qChan)
+
+	done := make(chan error, 1)
+	go func() {
+		var dest []int
+		done <- p.TryQueryAsync("select 1")(&dest)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPoolExhausted) {
+			t.Errorf("expected ErrPoolExhausted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TryQueryAsync waits for a connection")
+	}
+	if len(p.emptyQueryChan) != 1 || p.queued != 0 {
+		t.Errorf("expected the query released and no queued query, got %d, %d", len(p.emptyQueryChan), p.queued)
+	}
+}
+
+// TestPrepareAcquireTimeout checks that preparing a new statement does not wait beyond the AcquireTimeout for a query
+// when the pool is exhausted.
+func TestPrepareAcquireTimeout(t *testing.T) {
+	p := &Pap{
+		config:         cfg.Config{AcquireTimeout: 10 * time.Millisecond},
+		emptyQueryChan: make(chan *conn.Query, 1),
+		ps:             preparedStatements{list: make(map[string]*conn.Description)},
+	}
+	p.emptyQueryChan <- conn.NewQuery(nil, p.emptyQueryChan)
+
+	var dest []int
+	if err := p.QueryAsync("select 2")(&dest); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the acquire timeout, got %v", err)
+	}
+	if len(p.emptyQueryChan) != 1 {
+		t.Error("the query is not released")
+	}
+}
+
+// TestCommandQueueLimits checks that the commands dispatched without the dispatcher count in the queue and wait for
+// a query no longer than the AcquireTimeout.
+func TestCommandQueueLimits(t *testing.T) {
+	p := &Pap{
+		config:         cfg.Config{MaxQueueLength: 1, AcquireTimeout: 10 * time.Millisecond},
+		emptyQueryChan: make(chan *conn.Query),
+	}
+
+	if _, err := p.Begin(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Begin: expected the acquire timeout, got %v", err)
+	}
+	if _, err := p.CopyTo(context.Background(), io.Discard, "copy t to stdout"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CopyTo: expected the acquire timeout, got %v", err)
+	}
+
+	p.queued = 1
+	if _, err := p.Begin(context.Background()); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Begin: expected ErrPoolExhausted for a full queue, got %v", err)
+	}
+	if _, err := p.CopyTo(context.Background(), io.Discard, "copy t to stdout"); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("CopyTo: expected ErrPoolExhausted for a full queue, got %v", err)
+	}
+	if p.queued != 1 {
+		t.Errorf("expected 1 queued query, got %d", p.queued)
+	}
+
+	// a transaction stays open if its commit gets no query
+	tx := &Tx{p: p}
+	if err := tx.Commit(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Commit: expected the acquire timeout, got %v", err)
+	}
+	if tx.closed {
+		t.Error("the transaction is closed without a commit")
+	}
+}
+
+// closeServer returns the address of a fake server answering every query with 1. open counts the open connections.
+func closeServer(t *testing.T, open *int32) string {
+	return fakeServer(t, nil, func(b *pgproto.Backend) {
+		atomic.AddInt32(open, 1)
+		defer atomic.AddInt32(open, -1)
+		serveQueries(b, func(sql string, args [][]byte) fakeResult {
+			return fakeResult{oids: []uint32{pgtype.Int4OID}, rows: [][]interface{}{{int32(1)}}}
+		})
+	})
+}
+
+// waitClosed waits until the connections counted by open are closed.
+func waitClosed(t *testing.T, open *int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(open) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections are not closed", atomic.LoadInt32(open))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestClose checks that Close disconnects the connections and fails the queries after it.
+func TestClose(t *testing.T) {
+	var open int32
+	p, err := Start("postgres://u@" + closeServer(t, &open) + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitQueries(t, p)
+
+	p.Close()
+	p.Close()
+	waitClosed(t, &open)
+
+	var rows []struct {
+		N int32
+	}
+	if err := p.QueryAsync("select 1")(&rows); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+	if _, err := p.Begin(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+// TestCloseLatency checks that Close stops the latency measurement of load_balance_hosts=latency.
+func TestCloseLatency(t *testing.T) {
+	var open int32
+	p, err := Start("postgres://u@" + closeServer(t, &open) + "/db?sslmode=disable&load_balance_hosts=latency&latency_check_interval=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitQueries(t, p)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&open) <= 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("the latency is not measured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p.Close()
+	waitClosed(t, &open)
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"pap/internal/cfg"
+)
+
+const defaultReplicaCheckInterval = 5 * time.Second
+
+// replicaLagSQL returns the replay lag of a replica in seconds, zero if it replayed all received WAL.
+const replicaLagSQL = "select case when not pg_is_in_recovery() or pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() " +
+	"then 0 else coalesce(extract(epoch from now() - pg_last_xact_replay_timestamp()), 0) end"
+
+// ReadOnly returns the pool to send read-only queries to: the replica pool, or p if there are no replicas or all of
+// them are ejected. Queries which write must not be sent to it, e.g.
+//
+//	err := p.ReadOnly().QueryAsync("select ...")(&rows)
+func (p *Pap) ReadOnly() *Pap {
+	if p.replica == nil || !p.replica.health.available() {
+		return p
+	}
+	return p.replica
+}
+
+// health ejects the replicas of the replica pool which are unreachable or lag behind. The ready connections of an
+// ejected replica are parked until it is healthy again.
+type health struct {
+	p       *Pap
+	hosts   []*replicaHost
+	healthy int
+	mutex   sync.Mutex
+}
+
+type replicaHost struct {
+	config  *cfg.Config
+	ejected bool
+	parked  []int
+}
+
+func newHealth(p *Pap, configs []*cfg.Config) *health {
+	h := &health{
+		p:       p,
+		hosts:   make([]*replicaHost, len(configs)),
+		healthy: len(configs),
+	}
+	for i := range configs {
+		h.hosts[i] = &replicaHost{config: configs[i]}
+	}
+	return h
+}
+
+// available reports whether a replica is not ejected.
+func (h *health) available() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.healthy > 0
+}
+
+// park keeps the ready connection number if its replica is ejected.
+func (h *health) park(number int) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	host := h.hosts[number%len(h.hosts)]
+	if !host.ejected {
+		return false
+	}
+	host.parked = append(host.parked, number)
+	return true
+}
+
+func (h *health) setEjected(host *replicaHost, ejected bool) {
+	h.mutex.Lock()
+	if host.ejected == ejected {
+		h.mutex.Unlock()
+		return
+	}
+	host.ejected = ejected
+	if ejected {
+		h.healthy--
+		h.mutex.Unlock()
+		return
+	}
+	h.healthy++
+	parked := host.parked
+	host.parked = nil
+	h.mutex.Unlock()
+
+	for _, number := range parked {
+		h.p.announce(number)
+	}
+}
+
+// run checks the replicas until the pool is closed.
+func (h *health) run() {
+	for _, host := range h.hosts {
+		go h.check(host)
+	}
+}
+
+// check checks host every ReplicaCheckInterval, it is ejected if the check fails or the lag is too high.
+func (h *health) check(host *replicaHost) {
+	interval := h.p.config.ReplicaCheckInterval
+	if interval <= 0 {
+		interval = defaultReplicaCheckInterval
+	}
+	runChecks(h.p.done, h.p.config.ConnInfo, host.config, interval, replicaLag, func(lag time.Duration, err error) {
+		maxLag := h.p.config.MaxReplicaLag
+		h.setEjected(host, err != nil || (maxLag > 0 && lag > maxLag))
+	})
+}
+
+// replicaLag returns the replay lag of the replica of cc.
+func replicaLag(cc *checkConn) (time.Duration, error) {
+	value, err := cc.query(replicaLagSQL)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(string(value), 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pap/internal/cfg"
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+func TestParseConfigReplicas(t *testing.T) {
+	config, err := ParseConfig("host=primary port=5433 sslmode=disable replica_hosts=r1,r2:5434,/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs := config.ReplicaConfigs()
+	expected := []struct {
+		host string
+		port uint16
+	}{{"r1", 5433}, {"r2", 5434}, {"/tmp", 5433}}
+	if len(configs) != len(expected) {
+		t.Fatalf("expected %d replicas, got %d", len(expected), len(configs))
+	}
+	for i := range expected {
+		if configs[i].Host != expected[i].host || configs[i].Port != expected[i].port {
+			t.Errorf("replica %d: expected %s:%d, got %s:%d", i, expected[i].host, expected[i].port, configs[i].Host, configs[i].Port)
+		}
+	}
+}
+
+// TestReplicaAfterConnect checks that the health checks of the replicas skip AfterConnect and the connections of the
+// replica pool run it.
+func TestReplicaAfterConnect(t *testing.T) {
+	config, err := ParseConfig("host=primary sslmode=disable replica_hosts=r1,r2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AfterConnect = func(context.Context, Session) error {
+		return nil
+	}
+
+	configs := config.ReplicaConfigs()
+	for i, c := range configs {
+		if c.AfterConnect != nil || c.ValidateConnect != nil {
+			t.Errorf("replica %d: the connect hooks are set for the health checks", i)
+		}
+	}
+	for i, c := range config.HostConfigs() {
+		if c.AfterConnect != nil {
+			t.Errorf("host %d: AfterConnect is set for the latency checks", i)
+		}
+	}
+
+	replica := &Pap{config: *config}
+	replica.health = newHealth(replica, configs)
+	for i := 0; i < len(configs); i++ {
+		c := replica.connConfig(i)
+		if c.Host != configs[i].Host || c.AfterConnect == nil {
+			t.Errorf("connection %d: expected %s with AfterConnect, got %s", i, configs[i].Host, c.Host)
+		}
+	}
+}
+
+func TestHealthPark(t *testing.T) {
+	replica := &Pap{connReadyChan: make(chan int, 4)}
+	replica.health = newHealth(replica, []*cfg.Config{{}, {}})
+	p := &Pap{replica: replica}
+
+	if p.ReadOnly() != replica {
+		t.Fatal("expected the replica pool")
+	}
+
+	replica.health.setEjected(replica.health.hosts[1], true)
+	if replica.health.park(0) {
+		t.Error("connection 0 of a healthy replica is parked")
+	}
+	if !replica.health.park(3) {
+		t.Error("connection 3 of an ejected replica is not parked")
+	}
+
+	replica.health.setEjected(replica.health.hosts[0], true)
+	if p.ReadOnly() != p {
+		t.Error("expected the primary pool when all replicas are ejected")
+	}
+
+	replica.health.setEjected(replica.health.hosts[1], false)
+	if n := <-replica.connReadyChan; n != 3 {
+		t.Errorf("expected the parked connection 3, got %d", n)
+	}
+	if p.ReadOnly() != replica {
+		t.Error("expected the replica pool")
+	}
+}
+
+// TestRunChecksDone checks that the checks stop and close their connection when done is closed.
+func TestRunChecksDone(t *testing.T) {
+	var open int32
+	addr := fakeServer(t, nil, func(b *pgproto.Backend) {
+		atomic.AddInt32(&open, 1)
+		defer atomic.AddInt32(&open, -1)
+		serveQueries(b, func(sql string, args [][]byte) fakeResult {
+			return fakeResult{oids: []uint32{pgtype.Int4OID}, rows: [][]interface{}{{int32(1)}}}
+		})
+	})
+	config, err := ParseConfig("postgres://u@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	reports := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		runChecks(done, nil, config, 10*time.Millisecond, ping, func(latency time.Duration, err error) {
+			select {
+			case reports <- err:
+			default:
+			}
+		})
+		close(stopped)
+	}()
+
+	if err := <-reports; err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the checks do not stop")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&open) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the check connection is not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestHealthUnejectFull checks that the parked connections are put back without blocking while the ready connections
+// fill the channel.
+func TestHealthUnejectFull(t *testing.T) {
+	replica := &Pap{connReadyChan: make(chan int, 1)}
+	replica.health = newHealth(replica, []*cfg.Config{{}, {}})
+	host := replica.health.hosts[1]
+
+	replica.health.setEjected(host, true)
+	replica.health.park(1)
+	replica.health.park(3)
+	replica.connReadyChan <- 0
+
+	unejected := make(chan struct{})
+	go func() {
+		replica.health.setEjected(host, false)
+		close(unejected)
+	}()
+	select {
+	case <-unejected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("setEjected blocks on the full channel")
+	}
+
+	ready := map[int]bool{}
+	for len(ready) < 3 {
+		select {
+		case n := <-replica.connReadyChan:
+			ready[n] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the connections 0, 1 and 3, got %v", ready)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package replication
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LSN is a PostgreSQL Log Sequence Number, a byte position in the WAL.
+type LSN uint64
+
+// String formats the LSN value into the XXX/XXX format which is the text format used by PostgreSQL.
+func (lsn LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
+
+// ParseLSN parses the text format XXX/XXX of an LSN.
+func ParseLSN(s string) (LSN, error) {
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid LSN %s", s)
+	}
+
+	hi, err := strconv.ParseUint(s[:i], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %s: %w", s, err)
+	}
+	lo, err := strconv.ParseUint(s[i+1:], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %s: %w", s, err)
+	}
+
+	return LSN(hi<<32 | lo), nil
+}
@@ -0,0 +1,400 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package replication
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"pap/internal/pgtype"
+)
+
+// pgoutput message types
+const (
+	messageTypeBegin    = 'B'
+	messageTypeCommit   = 'C'
+	messageTypeRelation = 'R'
+	messageTypeInsert   = 'I'
+	messageTypeUpdate   = 'U'
+	messageTypeDelete   = 'D'
+	messageTypeTruncate = 'T'
+)
+
+// tuple column kinds
+const (
+	tupleDataNull      = 'n'
+	tupleDataUnchanged = 'u'
+	tupleDataText      = 't'
+	tupleDataBinary    = 'b'
+)
+
+// Old tuple kinds of UpdateMessage and DeleteMessage
+const (
+	OldTupleNone  = 0
+	OldTupleKey   = 'K' // the old tuple contains only the replica identity columns
+	OldTupleFull  = 'O' // the old tuple contains all columns, replica identity is full
+	newTupleMagic = 'N'
+)
+
+// Truncate options of TruncateMessage
+const (
+	TruncateCascade         = 1
+	TruncateRestartIdentity = 2
+)
+
+var errMessageTooShort = errors.New("pgoutput message is too short")
+
+// postgresEpoch is the zero of the timestamps of the replication protocol.
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Unchanged is the value of a TOASTed column which was not changed by an update. Its value is not sent.
+var Unchanged = unchangedToast{}
+
+type unchangedToast struct{}
+
+// Message is a decoded pgoutput message: *BeginMessage, *CommitMessage, *RelationMessage, *InsertMessage,
+// *UpdateMessage, *DeleteMessage or *TruncateMessage.
+type Message interface {
+	message()
+}
+
+// BeginMessage starts a transaction.
+type BeginMessage struct {
+	FinalLSN   LSN // LSN of the commit record of the transaction
+	CommitTime time.Time
+	Xid        uint32
+}
+
+// CommitMessage ends a transaction. TransactionEndLSN is the position to acknowledge after the transaction is
+// processed.
+type CommitMessage struct {
+	Flags             uint8
+	CommitLSN         LSN
+	TransactionEndLSN LSN
+	CommitTime        time.Time
+}
+
+// RelationColumn is a column of a RelationMessage.
+type RelationColumn struct {
+	Flags        uint8 // 1 marks the column as part of the key
+	Name         string
+	DataType     uint32
+	TypeModifier int32
+}
+
+// RelationMessage describes a table. It is sent before the first change of the table and after its definition
+// changed.
+type RelationMessage struct {
+	RelationID      uint32
+	Namespace       string
+	RelationName    string
+	ReplicaIdentity uint8
+	Columns         []RelationColumn
+}
+
+// InsertMessage is an inserted row. New holds the values of the columns of Relation, nil for NULL.
+type InsertMessage struct {
+	Relation *RelationMessage
+	New      []interface{}
+}
+
+// UpdateMessage is an updated row. Old is set depending on OldTupleType and the replica identity of Relation. Values
+// of not changed TOASTed columns are Unchanged.
+type UpdateMessage struct {
+	Relation     *RelationMessage
+	OldTupleType uint8 // OldTupleNone, OldTupleKey or OldTupleFull
+	Old          []interface{}
+	New          []interface{}
+}
+
+// DeleteMessage is a deleted row.
+type DeleteMessage struct {
+	Relation     *RelationMessage
+	OldTupleType uint8 // OldTupleKey or OldTupleFull
+	Old          []interface{}
+}
+
+// TruncateMessage truncates Relations.
+type TruncateMessage struct {
+	Options   uint8 // TruncateCascade and TruncateRestartIdentity bits
+	Relations []*RelationMessage
+}
+
+func (*BeginMessage) message()    {}
+func (*CommitMessage) message()   {}
+func (*RelationMessage) message() {}
+func (*InsertMessage) message()   {}
+func (*UpdateMessage) message()   {}
+func (*DeleteMessage) message()   {}
+func (*TruncateMessage) message() {}
+
+// decoder decodes pgoutput messages. It keeps the received relations to decode the tuples of changes.
+type decoder struct {
+	connInfo  *pgtype.ConnInfo
+	relations map[uint32]*RelationMessage
+}
+
+func newDecoder(connInfo *pgtype.ConnInfo) *decoder {
+	return &decoder{
+		connInfo:  connInfo,
+		relations: make(map[uint32]*RelationMessage),
+	}
+}
+
+// decode decodes the WAL data of an XLogData message. nil is returned for the message types which are not supported,
+// e.g. Origin and Type.
+func (d *decoder) decode(src []byte) (Message, error) {
+	if len(src) == 0 {
+		return nil, errMessageTooShort
+	}
+
+	r := &reader{src: src[1:]}
+	var msg Message
+	switch src[0] {
+	case messageTypeBegin:
+		msg = &BeginMessage{
+			FinalLSN:   LSN(r.uint64()),
+			CommitTime: r.time(),
+			Xid:        r.uint32(),
+		}
+	case messageTypeCommit:
+		msg = &CommitMessage{
+			Flags:             r.uint8(),
+			CommitLSN:         LSN(r.uint64()),
+			TransactionEndLSN: LSN(r.uint64()),
+			CommitTime:        r.time(),
+		}
+	case messageTypeRelation:
+		rel := &RelationMessage{
+			RelationID:      r.uint32(),
+			Namespace:       r.string(),
+			RelationName:    r.string(),
+			ReplicaIdentity: r.uint8(),
+		}
+		columns := int(r.uint16())
+		if r.err == nil {
+			rel.Columns = make([]RelationColumn, columns)
+			for i := range rel.Columns {
+				rel.Columns[i] = RelationColumn{
+					Flags:        r.uint8(),
+					Name:         r.string(),
+					DataType:     r.uint32(),
+					TypeModifier: int32(r.uint32()),
+				}
+			}
+		}
+		if r.err == nil {
+			d.relations[rel.RelationID] = rel
+		}
+		msg = rel
+	case messageTypeInsert:
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		if r.uint8() != newTupleMagic && r.err == nil {
+			return nil, errors.New("pgoutput insert without new tuple")
+		}
+		msg = &InsertMessage{Relation: rel, New: d.tuple(r, rel)}
+	case messageTypeUpdate:
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		m := &UpdateMessage{Relation: rel}
+		kind := r.uint8()
+		if kind == OldTupleKey || kind == OldTupleFull {
+			m.OldTupleType = kind
+			m.Old = d.tuple(r, rel)
+			kind = r.uint8()
+		}
+		if kind != newTupleMagic && r.err == nil {
+			return nil, errors.New("pgoutput update without new tuple")
+		}
+		m.New = d.tuple(r, rel)
+		msg = m
+	case messageTypeDelete:
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		m := &DeleteMessage{Relation: rel, OldTupleType: r.uint8()}
+		m.Old = d.tuple(r, rel)
+		msg = m
+	case messageTypeTruncate:
+		relations := int(r.uint32())
+		m := &TruncateMessage{Options: r.uint8()}
+		if r.err == nil {
+			m.Relations = make([]*RelationMessage, relations)
+			for i := range m.Relations {
+				rel, err := d.relation(r.uint32())
+				if err != nil && r.err == nil {
+					return nil, err
+				}
+				m.Relations[i] = rel
+			}
+		}
+		msg = m
+	default:
+		return nil, nil
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return msg, nil
+}
+
+func (d *decoder) relation(id uint32) (*RelationMessage, error) {
+	rel, ok := d.relations[id]
+	if !ok {
+		return nil, fmt.Errorf("pgoutput relation %d is unknown", id)
+	}
+	return rel, nil
+}
+
+// tuple decodes the TupleData of rel. Values are decoded with the data type registered for the column type, values of
+// not registered types are left as string or []byte.
+func (d *decoder) tuple(r *reader, rel *RelationMessage) []interface{} {
+	columns := int(r.uint16())
+	if r.err != nil {
+		return nil
+	}
+	if columns > len(rel.Columns) {
+		r.err = fmt.Errorf("pgoutput tuple has %d columns, relation %s has %d", columns, rel.RelationName, len(rel.Columns))
+		return nil
+	}
+
+	values := make([]interface{}, columns)
+	for i := range values {
+		kind := r.uint8()
+		switch kind {
+		case tupleDataNull:
+		case tupleDataUnchanged:
+			values[i] = Unchanged
+		case tupleDataText, tupleDataBinary:
+			data := r.next(int(r.uint32()))
+			if r.err != nil {
+				return nil
+			}
+			value, err := d.value(rel.Columns[i].DataType, kind, data)
+			if err != nil {
+				r.err = fmt.Errorf("pgoutput column %s: %w", rel.Columns[i].Name, err)
+				return nil
+			}
+			values[i] = value
+		default:
+			if r.err == nil {
+				r.err = fmt.Errorf("unknown pgoutput tuple column kind %c", kind)
+			}
+			return nil
+		}
+	}
+	return values
+}
+
+func (d *decoder) value(oid uint32, kind byte, data []byte) (interface{}, error) {
+	dt, ok := d.connInfo.DataTypeForOID(oid)
+	if !ok {
+		if kind == tupleDataText {
+			return string(data), nil
+		}
+		return data, nil
+	}
+
+	v := pgtype.NewValue(dt.Value)
+	if kind == tupleDataText {
+		td, ok := v.(pgtype.TextDecoder)
+		if !ok {
+			return string(data), nil
+		}
+		if err := td.DecodeText(d.connInfo, data); err != nil {
+			return nil, err
+		}
+	} else {
+		bd, ok := v.(pgtype.BinaryDecoder)
+		if !ok {
+			return data, nil
+		}
+		if err := bd.DecodeBinary(d.connInfo, data); err != nil {
+			return nil, err
+		}
+	}
+	return v.Get(), nil
+}
+
+// reader reads the fields of a message. The first error is kept in err, the following reads return zero values.
+type reader struct {
+	src []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.src) < n || n < 0 {
+		r.err = errMessageTooShort
+		return nil
+	}
+	b := r.src[:n]
+	r.src = r.src[n:]
+	return b
+}
+
+func (r *reader) uint8() uint8 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// time reads a timestamp in microseconds since the PostgreSQL epoch.
+func (r *reader) time() time.Time {
+	return postgresEpoch.Add(time.Duration(int64(r.uint64())) * time.Microsecond)
+}
+
+// string reads a null terminated string.
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i := range r.src {
+		if r.src[i] == 0 {
+			s := string(r.src[:i])
+			r.src = r.src[i+1:]
+			return s
+		}
+	}
+	r.err = errMessageTooShort
+	return ""
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package replication
+
+import (
+	"testing"
+
+	"pap/internal/pgtype"
+)
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+func relationMessage() []byte {
+	b := []byte{messageTypeRelation}
+	b = appendUint32(b, 16384)
+	b = appendString(b, "public")
+	b = appendString(b, "t")
+	b = append(b, 'd')
+	b = appendUint16(b, 2)
+	b = append(b, 1)
+	b = appendString(b, "id")
+	b = appendUint32(b, pgtype.Int8OID)
+	b = appendUint32(b, 0xFFFFFFFF)
+	b = append(b, 0)
+	b = appendString(b, "name")
+	b = appendUint32(b, pgtype.TextOID)
+	b = appendUint32(b, 0xFFFFFFFF)
+	return b
+}
+
+func TestDecodeInsert(t *testing.T) {
+	d := newDecoder(pgtype.NewConnInfo())
+
+	msg, err := d.decode(relationMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, ok := msg.(*RelationMessage)
+	if !ok || rel.RelationName != "t" || len(rel.Columns) != 2 || rel.Columns[1].Name != "name" {
+		t.Fatalf("unexpected relation %+v", msg)
+	}
+
+	b := []byte{messageTypeInsert}
+	b = appendUint32(b, 16384)
+	b = append(b, newTupleMagic)
+	b = appendUint16(b, 2)
+	b = append(b, tupleDataText)
+	b = appendUint32(b, 2)
+	b = append(b, "42"...)
+	b = append(b, tupleDataNull)
+
+	msg, err = d.decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert, ok := msg.(*InsertMessage)
+	if !ok {
+		t.Fatalf("unexpected message %T", msg)
+	}
+	if insert.Relation != rel || len(insert.New) != 2 || insert.New[0] != int64(42) || insert.New[1] != nil {
+		t.Fatalf("unexpected insert %+v", insert)
+	}
+}
+
+func TestDecodeUpdate(t *testing.T) {
+	d := newDecoder(pgtype.NewConnInfo())
+	if _, err := d.decode(relationMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	b := []byte{messageTypeUpdate}
+	b = appendUint32(b, 16384)
+	b = append(b, OldTupleKey)
+	b = appendUint16(b, 2)
+	b = append(b, tupleDataText)
+	b = appendUint32(b, 1)
+	b = append(b, '1')
+	b = append(b, tupleDataNull)
+	b = append(b, newTupleMagic)
+	b = appendUint16(b, 2)
+	b = append(b, tupleDataText)
+	b = appendUint32(b, 1)
+	b = append(b, '2')
+	b = append(b, tupleDataUnchanged)
+
+	msg, err := d.decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, ok := msg.(*UpdateMessage)
+	if !ok {
+		t.Fatalf("unexpected message %T", msg)
+	}
+	if update.OldTupleType != OldTupleKey || update.Old[0] != int64(1) {
+		t.Fatalf("unexpected old tuple %+v", update)
+	}
+	if update.New[0] != int64(2) || update.New[1] != Unchanged {
+		t.Fatalf("unexpected new tuple %+v", update)
+	}
+}
+
+func TestDecodeUnknownRelation(t *testing.T) {
+	d := newDecoder(pgtype.NewConnInfo())
+
+	b := []byte{messageTypeDelete}
+	b = appendUint32(b, 1)
+	b = append(b, OldTupleKey)
+	b = appendUint16(b, 0)
+
+	if _, err := d.decode(b); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDecodeTooShort(t *testing.T) {
+	d := newDecoder(pgtype.NewConnInfo())
+
+	if _, err := d.decode([]byte{messageTypeBegin, 0, 0}); err != errMessageTooShort {
+		t.Fatalf("expected %v, got %v", errMessageTooShort, err)
+	}
+}
+
+func TestParseLSN(t *testing.T) {
+	lsn, err := ParseLSN("16/B374D848")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lsn != LSN(0x16B374D848) {
+		t.Fatalf("unexpected lsn %d", lsn)
+	}
+	if lsn.String() != "16/B374D848" {
+		t.Fatalf("unexpected string %s", lsn)
+	}
+
+	if _, err := ParseLSN("16B374D848"); err == nil {
+		t.Fatal("expected error")
+	}
+}
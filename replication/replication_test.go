@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package replication
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"pap/internal/cfg"
+	"pap/internal/pgproto"
+)
+
+// statusUpdate is a standby status update received by the fake server.
+type statusUpdate struct {
+	written, flushed, applied LSN
+}
+
+// replicationServer is a fake server streaming one XLogData message with relationMessage at walStart, then requesting
+// a status update by a keepalive once the client received it and again once ackDone is closed. The replication
+// statement and the status updates are passed to the test.
+type replicationServer struct {
+	addr    string
+	sql     chan string
+	updates chan statusUpdate
+	ackDone chan struct{}
+}
+
+const walStart = LSN(0x1000)
+
+func newReplicationServer(t *testing.T) *replicationServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &replicationServer{
+		addr:    ln.Addr().String(),
+		sql:     make(chan string, 1),
+		updates: make(chan statusUpdate, 2),
+		ackDone: make(chan struct{}),
+	}
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		s.serve(t, pgproto.NewBackend(pgproto.NewChunkReader(c), c))
+	}()
+	return s
+}
+
+func (s *replicationServer) serve(t *testing.T, b *pgproto.Backend) {
+	msg, err := b.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+	if startup, ok := msg.(*pgproto.StartupMessage); !ok || startup.Parameters["replication"] != "database" {
+		t.Errorf("expected a replication=database startup message, got %+v", msg)
+		return
+	}
+	b.Send(&pgproto.AuthenticationOk{})
+	b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+
+	msg, err = b.Receive()
+	if err != nil {
+		return
+	}
+	query, ok := msg.(*pgproto.Query)
+	if !ok {
+		t.Errorf("expected a query, got %T", msg)
+		return
+	}
+	s.sql <- query.String
+	b.Send(&pgproto.CopyBothResponse{})
+
+	xLogData := []byte{xLogDataType}
+	xLogData = appendUint32(xLogData, uint32(walStart>>32))
+	xLogData = appendUint32(xLogData, uint32(walStart))
+	xLogData = append(xLogData, make([]byte, 16)...) // server WAL end and time
+	xLogData = append(xLogData, relationMessage()...)
+	b.Send(&pgproto.CopyData{Data: xLogData})
+
+	keepalive := []byte{primaryKeepaliveType}
+	keepalive = append(keepalive, make([]byte, 16)...)
+	keepalive = append(keepalive, 1) // reply requested
+	for i := 0; i < 2; i++ {
+		if i == 1 {
+			<-s.ackDone
+		}
+		b.Send(&pgproto.CopyData{Data: keepalive})
+
+		msg, err := b.Receive()
+		if err != nil {
+			return
+		}
+		data, ok := msg.(*pgproto.CopyData)
+		if !ok || len(data.Data) != 34 || data.Data[0] != standbyStatusUpdateType {
+			t.Errorf("expected a standby status update, got %+v", msg)
+			return
+		}
+		s.updates <- statusUpdate{
+			written: LSN(binary.BigEndian.Uint64(data.Data[1:])),
+			flushed: LSN(binary.BigEndian.Uint64(data.Data[9:])),
+			applied: LSN(binary.BigEndian.Uint64(data.Data[17:])),
+		}
+	}
+
+	b.Send(&pgproto.CopyDone{})
+	for {
+		if _, err := b.Receive(); err != nil {
+			return
+		}
+	}
+}
+
+func TestStartReplication(t *testing.T) {
+	s := newReplicationServer(t)
+	var config cfg.Config
+	if err := config.ParseConfig("postgres://u@" + s.addr + "/db?sslmode=disable"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := ConnectConfig(ctx, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	err = c.StartReplication(ctx, "pap_slot", LSN(0x10), StartReplicationOptions{
+		PluginArgs:            []string{"proto_version '1'", "publication_names 'pap_pub'"},
+		StandbyMessageTimeout: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `START_REPLICATION SLOT "pap_slot" LOGICAL 0/10 (proto_version '1', publication_names 'pap_pub')`
+	if sql := <-s.sql; sql != expected {
+		t.Errorf("expected %s, got %s", expected, sql)
+	}
+	if err := c.StartReplication(ctx, "pap_slot", 0, StartReplicationOptions{}); err != ErrStreaming {
+		t.Errorf("expected ErrStreaming, got %v", err)
+	}
+
+	msg, err := c.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel, ok := msg.(*RelationMessage); !ok || rel.RelationName != "t" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+
+	// the keepalives are answered while the next message is awaited
+	received := make(chan error, 1)
+	go func() {
+		_, err := c.Receive(ctx)
+		received <- err
+	}()
+
+	written := walStart + LSN(len(relationMessage()))
+	if u := <-s.updates; u.written != written || u.flushed != 0 || u.applied != 0 {
+		t.Errorf("expected written %s and nothing flushed, got %+v", written, u)
+	}
+
+	c.Ack(walStart)
+	close(s.ackDone)
+	if u := <-s.updates; u.written != written || u.flushed != walStart || u.applied != walStart {
+		t.Errorf("expected written %s and flushed %s, got %+v", written, walStart, u)
+	}
+
+	if err := <-received; err != ErrClosed {
+		t.Errorf("expected ErrClosed after CopyDone, got %v", err)
+	}
+	if _, err := c.IdentifySystem(ctx); err != ErrStreaming {
+		t.Errorf("expected ErrStreaming, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"time"
+
+	"pap/internal/conn"
+)
+
+// retireCheckInterval is the interval in which the ready connections are checked for their max lifetime and idle time.
+const retireCheckInterval = time.Second
+
+// retireConns retires the expired connections every retireCheckInterval until the pool is closed.
+func (p *Pap) retireConns() {
+	ticker := time.NewTicker(retireCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.retire(now)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// retire retires the ready connections that exceeded their max lifetime or idle time at now. A connection announces
+// itself ready before it executes a command, so it may still execute a query: the retire command is queued behind it
+// and the query is not interrupted, the connection reconnects in the background when it is done. The other ready
+// connections are put back.
+func (p *Pap) retire(now time.Time) {
+	for n := len(p.connReadyChan); n > 0; n-- {
+		var cr int
+		select {
+		case cr = <-p.connReadyChan:
+		default:
+			return
+		}
+
+		if !p.conns.list[cr].expired(now, p.config.MaxConnIdleTime) {
+			p.announce(cr)
+			continue
+		}
+		// a pinned connection announces itself again when its transaction ends. The connection records the time it
+		// reconnected, it is retired again if that fails.
+		if p.conns.list[cr].send(conn.Command{CommandType: conn.CommandRetire}) {
+			p.conns.list[cr].setLifetime(p.config.MaxConnLifetime, p.config.MaxConnLifetimeJitter)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pap/internal/cfg"
+	"pap/internal/conn"
+)
+
+func TestRetire(t *testing.T) {
+	p := &Pap{
+		config:        cfg.Config{MaxConnLifetime: time.Hour, MaxConnLifetimeJitter: time.Minute, MaxConnIdleTime: time.Minute},
+		connReadyChan: make(chan int, 3),
+		conns:         &connections{list: make([]connection, 3)},
+	}
+	start := time.Now()
+	for i := range p.conns.list {
+		p.conns.list[i].commandChan = make(chan conn.Command, 1)
+		p.conns.list[i].created = start.UnixNano()
+		p.conns.list[i].setLifetime(p.config.MaxConnLifetime, p.config.MaxConnLifetimeJitter)
+		p.connReadyChan <- i
+	}
+
+	// 0 is idle, 1 completed a command recently, 2 executes a command and exceeds its max lifetime later
+	p.conns.list[0].idleSince = start.UnixNano()
+	p.conns.list[1].idleSince = start.Add(50 * time.Second).UnixNano()
+	p.retire(start.Add(70 * time.Second))
+	if len(p.conns.list[0].commandChan) != 1 || len(p.conns.list[1].commandChan) != 0 || len(p.conns.list[2].commandChan) != 0 {
+		t.Fatal("expected only the idle connection to be retired")
+	}
+	if cmd := <-p.conns.list[0].commandChan; cmd.CommandType != conn.CommandRetire {
+		t.Errorf("unexpected command %d", cmd.CommandType)
+	}
+	if len(p.connReadyChan) != 2 {
+		t.Errorf("expected 2 ready connections, got %d", len(p.connReadyChan))
+	}
+
+	p.retire(start.Add(2*time.Hour + 10*time.Second))
+	if len(p.conns.list[2].commandChan) != 1 {
+		t.Error("expected the connection to be retired after its max lifetime")
+	}
+}
+
+// TestRetireBusy checks that a connection announced as ready while it executes a long query is not idle.
+func TestRetireBusy(t *testing.T) {
+	var connected int32
+	config := hangServer(t, &connected)
+	config.MaxConnIdleTime = time.Minute
+	p, err := StartConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitQueries(t, p)
+	for i := 0; i < 10; i++ {
+		if atomic.LoadInt64(&p.conns.list[i].created) == 0 {
+			t.Fatalf("connection %d did not record its connect time", i)
+		}
+	}
+
+	go p.QueryAsync(sqlHang)(nil)
+	busy := -1
+	deadline := time.Now().Add(5 * time.Second)
+	for busy < 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the query is not executed")
+		}
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 10; i++ {
+			if atomic.LoadInt64(&p.conns.list[i].idleSince) == 0 {
+				busy = i
+			}
+		}
+	}
+
+	// the retired connections record the time they reconnected
+	before := time.Now().UnixNano()
+	p.retire(time.Now().Add(2 * config.MaxConnIdleTime))
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		retired := 0
+		for i := 0; i < 10; i++ {
+			if i != busy && atomic.LoadInt64(&p.conns.list[i].created) > before {
+				retired++
+			}
+		}
+		if retired == 9 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 9 idle connections are retired", retired)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt64(&p.conns.list[busy].created) > before {
+		t.Errorf("connection %d is retired while it executes a query", busy)
+	}
+}
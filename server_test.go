@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+// fakeServer starts a PostgreSQL server for tests and returns its address. auth authenticates a connection after its
+// startup message and returns false to close it, nil accepts all connections without authentication. Authenticated
+// connections get AuthenticationOk and ReadyForQuery and are then passed to serve, nil discards their messages.
+func fakeServer(t *testing.T, auth func(b *pgproto.Backend) bool, serve func(b *pgproto.Backend)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				b := pgproto.NewBackend(pgproto.NewChunkReader(c), c)
+				if _, err := b.ReceiveStartupMessage(); err != nil {
+					return
+				}
+				if auth != nil && !auth(b) {
+					return
+				}
+
+				b.Send(&pgproto.AuthenticationOk{})
+				b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+				if serve == nil {
+					io.Copy(io.Discard, c)
+					return
+				}
+				serve(b)
+			}(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// fakeResult is the result of a statement on the fake server.
+type fakeResult struct {
+	params  []uint32        // the parameter types
+	oids    []uint32        // the column types
+	rows    [][]interface{} // the values are encoded by pgtype in the format requested by the client
+	tag     string
+	err     string   // the message of an ErrorResponse instead of the result
+	notices []string // the messages of the NoticeResponses sent before the result
+
+	// serve answers a simple query or Execute instead of the result, e.g. for COPY, ReadyForQuery is sent after it
+	serve func(b *pgproto.Backend)
+}
+
+var fakeConnInfo = pgtype.NewConnInfo()
+
+// serveQueries answers the simple queries and the extended protocol of a fake server connection with the results of
+// result by SQL and the arguments as sent by the client, until the connection is terminated. args is nil if the
+// statement is only described or executed by a simple query. A fast-path function call is answered as the statement
+// "function <oid>" with the first value of the result.
+func serveQueries(b *pgproto.Backend, result func(sql string, args [][]byte) fakeResult) {
+	statements := make(map[string]string)
+	var portal string
+	args := [][]byte{}
+	var formats []int16
+	for {
+		msg, err := b.Receive()
+		if err != nil {
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.Query:
+			r := result(msg.String, nil)
+			if r.serve != nil {
+				r.serve(b)
+			} else if r.err != "" {
+				b.Send(&pgproto.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: r.err})
+			} else {
+				sendResult(b, r, nil)
+			}
+			b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto.Parse:
+			statements[msg.Name] = msg.Query
+			b.Send(&pgproto.ParseComplete{})
+		case *pgproto.Describe:
+			if msg.ObjectType == 'S' {
+				r := result(statements[msg.Name], nil)
+				b.Send(&pgproto.ParameterDescription{ParameterOIDs: r.params})
+				b.Send(rowDescription(r, nil))
+			} else {
+				b.Send(rowDescription(result(portal, args), formats))
+			}
+		case *pgproto.Bind:
+			portal = statements[msg.PreparedStatement]
+			args = args[:0]
+			for _, arg := range msg.Parameters {
+				args = append(args, append([]byte(nil), arg...))
+			}
+			formats = append(formats[:0], msg.ResultFormatCodes...)
+			b.Send(&pgproto.BindComplete{})
+		case *pgproto.Execute:
+			r := result(portal, args)
+			if r.serve != nil {
+				r.serve(b)
+			} else if r.err != "" {
+				b.Send(&pgproto.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: r.err})
+			} else {
+				sendResult(b, r, formats)
+			}
+		case *pgproto.FunctionCall:
+			fnArgs := make([][]byte, len(msg.Arguments))
+			for i, arg := range msg.Arguments {
+				fnArgs[i] = append([]byte(nil), arg...)
+			}
+			r := result("function "+strconv.FormatUint(uint64(msg.Function), 10), fnArgs)
+			if r.err != "" {
+				b.Send(&pgproto.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: r.err})
+			} else {
+				var value []byte
+				if len(r.rows) > 0 {
+					value = encodeValue(r.oids[0], int16(msg.ResultFormatCode), r.rows[0][0])
+				}
+				b.Send(&pgproto.FunctionCallResponse{Result: value})
+			}
+			b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto.Close:
+			b.Send(&pgproto.CloseComplete{})
+		case *pgproto.Sync:
+			b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto.Terminate:
+			return
+		}
+	}
+}
+
+// resultFormat returns the format of column i requested by formats of Bind.
+func resultFormat(formats []int16, i int) int16 {
+	switch len(formats) {
+	case 0:
+		return 0
+	case 1:
+		return formats[0]
+	default:
+		return formats[i]
+	}
+}
+
+func rowDescription(r fakeResult, formats []int16) pgproto.BackendMessage {
+	if len(r.oids) == 0 {
+		return &pgproto.NoData{}
+	}
+	fields := make([]pgproto.FieldDescription, len(r.oids))
+	for i, oid := range r.oids {
+		fields[i] = pgproto.FieldDescription{
+			Name:         []byte("c" + strconv.Itoa(i)),
+			DataTypeOID:  oid,
+			DataTypeSize: -1,
+			TypeModifier: -1,
+			Format:       resultFormat(formats, i),
+		}
+	}
+	return &pgproto.RowDescription{Fields: fields}
+}
+
+func sendResult(b *pgproto.Backend, r fakeResult, formats []int16) {
+	for _, notice := range r.notices {
+		b.Send(&pgproto.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: notice})
+	}
+	if formats == nil && len(r.oids) > 0 {
+		b.Send(rowDescription(r, nil))
+	}
+	for _, row := range r.rows {
+		values := make([][]byte, len(row))
+		for i, v := range row {
+			values[i] = encodeValue(r.oids[i], resultFormat(formats, i), v)
+		}
+		b.Send(&pgproto.DataRow{Values: values})
+	}
+	tag := r.tag
+	if tag == "" {
+		tag = "SELECT " + strconv.Itoa(len(r.rows))
+	}
+	b.Send(&pgproto.CommandComplete{CommandTag: []byte(tag)})
+}
+
+// encodeValue encodes v as the type oid in format, nil is NULL. A []byte is sent as is.
+func encodeValue(oid uint32, format int16, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return v
+	}
+	dt, ok := fakeConnInfo.DataTypeForOID(oid)
+	if !ok {
+		panic("unknown oid " + strconv.Itoa(int(oid)))
+	}
+	value := pgtype.NewValue(dt.Value)
+	if err := value.Set(v); err != nil {
+		panic(err)
+	}
+
+	var buf []byte
+	var err error
+	if format == 1 {
+		buf, err = value.(pgtype.BinaryEncoder).EncodeBinary(fakeConnInfo, nil)
+	} else {
+		buf, err = value.(pgtype.TextEncoder).EncodeText(fakeConnInfo, nil)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// fakePoolConfig returns the config of a pool on a fake server answering queries with result, see serveQueries.
+func fakePoolConfig(t *testing.T, result func(sql string, args [][]byte) fakeResult) *Config {
+	addr := fakeServer(t, nil, func(b *pgproto.Backend) {
+		serveQueries(b, result)
+	})
+	config, err := ParseConfig("postgres://u@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// startFakePool starts a pool on a fake server answering queries with result, see serveQueries.
+func startFakePool(t *testing.T, result func(sql string, args [][]byte) fakeResult) *Pap {
+	p, err := StartConfig(fakePoolConfig(t, result))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -5,37 +5,128 @@
 package pap
 
 import (
+	"context"
 	"sync"
 
 	"pap/internal/cfg"
 	"pap/internal/conn"
+	"pap/internal/pgtype"
 )
 
-func Start(connString string) (*Pap, error) {
+// Config is the configuration of pap, it must be created by ParseConfig.
+type Config = cfg.Config
+
+// Notice is a notice response message reported by the PostgreSQL server, see Config.OnNotice.
+type Notice = cfg.Notice
+
+// Session is the connection passed to Config.ValidateConnect and Config.AfterConnect during a connection attempt.
+type Session = cfg.Session
+
+// ValidateConnectFunc validates the server of a connection attempt, see Config.ValidateConnect.
+type ValidateConnectFunc = cfg.ValidateConnectFunc
+
+// AfterConnectFunc sets up a new connection before it is used, see Config.AfterConnect.
+type AfterConnectFunc = cfg.AfterConnectFunc
+
+// PasswordProvider returns the password for a new connection, see Config.PasswordProvider.
+type PasswordProvider = cfg.PasswordProvider
+
+// OAuthTokenProvider returns the bearer token for OAUTHBEARER authentication, see Config.OAuthTokenProvider.
+type OAuthTokenProvider = cfg.OAuthTokenProvider
+
+// OAuthTokenRequest describes the token needed for a connection attempt, see OAuthTokenProvider.
+type OAuthTokenRequest = cfg.OAuthTokenRequest
+
+// OAuthError is the rejection of a bearer token by the server.
+type OAuthError = cfg.OAuthError
+
+// ParseConfig parses connString into a Config. The returned config can be modified (e.g. to set ConnInfo) before
+// passing it to StartConfig.
+func ParseConfig(connString string) (*Config, error) {
 	var config cfg.Config
 	err := config.ParseConfig(connString)
 	if err != nil {
 		return nil, err
 	}
 
-	var p = &Pap{
-		config: config,
+	return &config, nil
+}
+
+func Start(connString string) (*Pap, error) {
+	config, err := ParseConfig(connString)
+	if err != nil {
+		return nil, err
 	}
 
-	conns := make([]connection, max)
-	emptyQueryChan := make(chan *conn.Query, eMax)
-	p.emptyQueryChan = emptyQueryChan
+	return StartConfig(config)
+}
 
-	queries := NewQueries(cap(emptyQueryChan), emptyQueryChan)
-	p.queries = queries
+// StartConfig starts pap with config, the config is copied. If config has replicas, a replica pool is started for
+// ReadOnly.
+func StartConfig(config *Config) (*Pap, error) {
+	config = config.Copy()
+	connInfo := config.ConnInfo
+	if connInfo == nil {
+		connInfo = pgtype.NewConnInfo()
+		config.ConnInfo = connInfo
+	}
 
+	emptyQueryChan := make(chan *conn.Query, eMax)
+	queries := NewQueries(cap(emptyQueryChan), emptyQueryChan, connInfo)
 	for i := range queries.list {
 		emptyQueryChan <- queries.list[i]
 	}
 
-	qChan := make(chan *conn.Query, max)
+	p := newPool(config, queries, emptyQueryChan)
+	if config.Balancer != nil && config.Balancer.Policy() == cfg.LoadBalanceLatency {
+		measureLatency(config, p.done)
+	}
+	p.connect(10)
+
+	if replicaConfigs := config.ReplicaConfigs(); len(replicaConfigs) > 0 {
+		p.replica = newPool(config, queries, emptyQueryChan)
+		p.replica.health = newHealth(p.replica, replicaConfigs)
+		p.replica.connect(10)
+		go p.replica.health.run()
+	}
+
+	return p, nil
+}
+
+// Close stops the background checks of p and its replica pool and disconnects their connections. The commands waiting
+// for a connection fail with ErrPoolClosed. Transactions, copies and subscriptions must end before Close, p must not
+// be used after.
+func (p *Pap) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		if p.replica != nil {
+			p.replica.Close()
+		}
+
+		p.conns.mutex.Lock()
+		defer p.conns.mutex.Unlock()
+		for i := range p.conns.list {
+			if p.conns.list[i].status == connStatusOnline {
+				p.conns.list[i].sendAny(conn.Command{CommandType: conn.CommandDisconnect})
+				p.conns.list[i].status = connStatusOffline
+			}
+		}
+	})
+}
+
+// newPool starts the connection goroutines and the dispatcher of a pool sharing queries with the other pools.
+func newPool(config *cfg.Config, queries *Queries, emptyQueryChan chan *conn.Query) *Pap {
+	var p = &Pap{
+		config:         *config,
+		queries:        queries,
+		emptyQueryChan: emptyQueryChan,
+		done:           make(chan struct{}),
+	}
+
+	conns := make([]connection, max)
+
+	qChan := make(chan queuedQuery, max)
 	p.queryChan = qChan
-	commandChans := make([]chan conn.Command, max)
 
 	connReadyChan := make(chan int, max)
 	p.connReadyChan = connReadyChan
@@ -43,8 +134,7 @@ func Start(connString string) (*Pap, error) {
 	for i := range conns {
 		cChan := make(chan conn.Command, min)
 		conns[i].commandChan = cChan
-		conn.Start(i, cChan, connReadyChan)
-		commandChans[i] = cChan
+		conn.Start(i, cChan, connReadyChan, &conns[i].idleSince, &conns[i].created)
 	}
 	p.conns = &connections{list: conns}
 
@@ -53,28 +143,131 @@ func Start(connString string) (*Pap, error) {
 		mutex: sync.RWMutex{},
 	}
 
-	p.connect(10)
+	p.functions = functions{
+		byName: make(map[string]*function),
+		byOID:  make(map[uint32]*function),
+		mutex:  sync.RWMutex{},
+	}
 
-	go p.start(
-		qChan,
-		connReadyChan,
-	)
+	go p.start(qChan)
+	if config.MaxConnLifetime > 0 || config.MaxConnIdleTime > 0 {
+		go p.retireConns()
+	}
 
-	return p, nil
+	return p
 }
 
+// start dispatches the queued queries to the ready connections. A query fails if its context is done before a
+// connection is ready.
 func (p *Pap) start(
-	qChan chan *conn.Query,
-	connReadyChan chan int,
+	qChan chan queuedQuery,
 ) {
-	var q *conn.Query
-	var cr int
+	for qq := range qChan {
+		err := p.dispatchQueued(qq)
+		qq.cancel()
+		p.dequeue()
+		if err != nil {
+			qq.q.Fail(err)
+		}
+	}
+}
+
+// dispatchQueued dispatches qq to a ready connection and returns the error to fail qq with if its context is done
+// first. A query failed by its watcher meanwhile is skipped, the connection taken for it is put back.
+func (p *Pap) dispatchQueued(qq queuedQuery) error {
+	err := qq.ctx.Err()
+	cr := 0
+	if err == nil {
+		cr, err = p.readyConnContext(qq.ctx)
+	}
+	if !qq.claim() {
+		if err == nil {
+			p.announce(cr)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd := conn.Command{
+		CommandType: conn.CommandPreparedQuery,
+		Query:       qq.q,
+	}
+	if p.conns.list[cr].send(cmd) {
+		return nil
+	}
+	// the connection was pinned after its announcement
+	_, err = p.dispatch(qq.ctx, cmd)
+	return err
+}
+
+// announce puts the ready connection cr back. The connections announced meanwhile may fill the channel, then it is put
+// back in the background.
+func (p *Pap) announce(cr int) {
+	select {
+	case p.connReadyChan <- cr:
+	default:
+		go func() {
+			p.connReadyChan <- cr
+		}()
+	}
+}
+
+// dispatch sends cmd to a ready connection and returns its number. The connections pinned to a transaction are
+// skipped.
+func (p *Pap) dispatch(ctx context.Context, cmd conn.Command) (int, error) {
+	for {
+		cr, err := p.readyConnContext(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if p.conns.list[cr].send(cmd) {
+			return cr, nil
+		}
+	}
+}
+
+// tryDispatch is dispatch which returns ErrPoolExhausted instead of waiting if no connection is ready.
+func (p *Pap) tryDispatch(cmd conn.Command) (int, error) {
 	for {
-		q = <-qChan
-		cr = <-connReadyChan
-		p.conns.list[cr].commandChan <- conn.Command{
-			CommandType: conn.CommandPreparedQuery,
-			Query:       q,
+		select {
+		case <-p.done:
+			return 0, ErrPoolClosed
+		default:
+		}
+		select {
+		case cr := <-p.connReadyChan:
+			if p.health != nil && p.health.park(cr) {
+				continue
+			}
+			if p.conns.list[cr].send(cmd) {
+				return cr, nil
+			}
+		default:
+			return 0, ErrPoolExhausted
+		}
+	}
+}
+
+// readyConnContext waits for a ready connection and returns its number, or the error of ctx if it is done first. The
+// connections of ejected replicas are skipped. ErrPoolClosed is returned once the pool is closed.
+func (p *Pap) readyConnContext(ctx context.Context) (int, error) {
+	for {
+		select {
+		case <-p.done:
+			return 0, ErrPoolClosed
+		default:
+		}
+		select {
+		case cr := <-p.connReadyChan:
+			if p.health == nil || !p.health.park(cr) {
+				return cr, nil
+			}
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-p.done:
+			return 0, ErrPoolClosed
 		}
 	}
 }
@@ -0,0 +1,417 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package stdlib
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"strings"
+
+	"pap/internal/conn"
+	"pap/internal/pgtype"
+)
+
+// psCacheSize is the maximum number of statements prepared for the queries executed without Prepare. The least
+// recently prepared statement is deallocated when it is exceeded.
+const psCacheSize = 256
+
+// Conn is a database/sql connection backed by one dedicated PostgreSQL connection.
+type Conn struct {
+	commandChan    chan conn.Command
+	connReadyChan  chan int
+	emptyQueryChan chan *conn.Query
+	queries        int
+	connInfo       *pgtype.ConnInfo
+	ps             map[string]*conn.Description // the cached statements by SQL
+	psOrder        []string                     // the SQL of the cached statements in the order of preparation
+	psNumber       int                          // numbers the names of the prepared statements
+	closed         bool
+	broken         bool // the server connection failed, database/sql discards the connection
+}
+
+// acquireQuery returns a free query, new queries are allocated up to queriesPerConn.
+func (c *Conn) acquireQuery() *conn.Query {
+	select {
+	case q := <-c.emptyQueryChan:
+		return q
+	default:
+	}
+	if c.queries < cap(c.emptyQueryChan) {
+		c.queries++
+		return conn.NewQuery(c.connInfo, c.emptyQueryChan)
+	}
+	return <-c.emptyQueryChan
+}
+
+// send waits until the connection is ready and passes it the query. q must be locked, it is unlocked by the
+// connection after execution, so the caller waits for the result by locking q again. If ctx is done before the
+// connection is ready, its error is returned and q stays locked, the command is canceled on the server if ctx is done
+// later. The returned error is the error of the result mapped by resultError.
+func (c *Conn) send(ctx context.Context, commandType byte, q *conn.Query) error {
+	select {
+	case <-c.connReadyChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	c.commandChan <- conn.Command{
+		CommandType: commandType,
+		Query:       q,
+		Done:        ctx.Done(),
+	}
+	q.Mutex.Lock()
+	return c.resultError(ctx, q.R.Error())
+}
+
+// resultError maps the error of an executed command. The error of ctx is returned if ctx canceled the command. If the
+// server connection failed, the connection is marked as broken and driver.ErrBadConn is returned if database/sql can
+// safely retry the command on another connection.
+func (c *Conn) resultError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pgErr *conn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Severity == "FATAL" {
+		c.broken = true
+		if conn.SafeToRetry(err) {
+			return driver.ErrBadConn
+		}
+		return err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// prepare prepares a statement for sql.
+func (c *Conn) prepare(ctx context.Context, sql string) (*conn.Description, error) {
+	q := c.acquireQuery()
+	q.Mutex.Lock()
+	defer q.Close()
+	err := q.Start(sql)
+	if err != nil {
+		return nil, err
+	}
+	q.D = &conn.Description{Name: "pap_stdlib_ps_" + strconv.Itoa(c.psNumber)}
+	c.psNumber++
+	if err = c.send(ctx, conn.CommandPrepare, q); err != nil {
+		return nil, err
+	}
+	q.AppendResultFormat()
+
+	return q.D, nil
+}
+
+// deallocate closes the prepared statement of d on the server.
+func (c *Conn) deallocate(d *conn.Description) error {
+	if c.closed || c.broken {
+		return nil
+	}
+
+	q := c.acquireQuery()
+	q.Mutex.Lock()
+	defer q.Close()
+	err := q.Start("")
+	if err != nil {
+		return err
+	}
+	q.D = d
+	return c.send(context.Background(), conn.CommandDeallocate, q)
+}
+
+// describe returns the description of the cached prepared statement for sql, the statement is prepared on first use.
+func (c *Conn) describe(ctx context.Context, sql string) (*conn.Description, error) {
+	if d, ok := c.ps[sql]; ok {
+		return d, nil
+	}
+
+	d, err := c.prepare(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.psOrder) == psCacheSize {
+		if err := c.deallocate(c.ps[c.psOrder[0]]); err != nil {
+			return nil, err
+		}
+		delete(c.ps, c.psOrder[0])
+		c.psOrder = c.psOrder[1:]
+	}
+	c.ps[sql] = d
+	c.psOrder = append(c.psOrder, sql)
+
+	return d, nil
+}
+
+// query executes the cached prepared statement for sql. See queryDescription.
+func (c *Conn) query(ctx context.Context, sql string, args []interface{}) (*conn.Query, error) {
+	if c.closed || c.broken {
+		return nil, driver.ErrBadConn
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d, err := c.describe(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return c.queryDescription(ctx, d, sql, args)
+}
+
+// queryDescription executes the prepared statement of d and returns the locked query holding the result. The caller
+// must close it.
+func (c *Conn) queryDescription(ctx context.Context, d *conn.Description, sql string, args []interface{}) (*conn.Query, error) {
+	if c.closed || c.broken {
+		return nil, driver.ErrBadConn
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	q := c.acquireQuery()
+	q.Mutex.Lock()
+	err := q.Start(sql, args...)
+	if err != nil {
+		q.Close()
+		return nil, err
+	}
+	q.D = d
+
+	for i := range q.Args {
+		err = q.AppendParam(i)
+		if err != nil {
+			q.Close()
+			return nil, err
+		}
+	}
+
+	if err = c.send(ctx, conn.CommandPreparedQuery, q); err != nil {
+		q.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (c *Conn) exec(ctx context.Context, sql string, args []interface{}) (driver.Result, error) {
+	q, err := c.query(ctx, sql, args)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(q), nil
+}
+
+func execResult(q *conn.Query) driver.Result {
+	defer q.Close()
+	return driver.RowsAffected(q.R.CommandTag().RowsAffected())
+}
+
+// Prepare returns a prepared statement, bound to this connection.
+func (c *Conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext returns a prepared statement, bound to this connection. The statement is deallocated by Stmt.Close.
+func (c *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.closed || c.broken {
+		return nil, driver.ErrBadConn
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stmt{sql: query, d: d, conn: c}, nil
+}
+
+// IsValid reports whether the connection can be reused, it implements driver.Validator.
+func (c *Conn) IsValid() bool {
+	return !c.closed && !c.broken
+}
+
+// ResetSession returns driver.ErrBadConn if the server connection failed, it implements driver.SessionResetter.
+func (c *Conn) ResetSession(context.Context) error {
+	if !c.IsValid() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// Close terminates the connection and stops its goroutine.
+func (c *Conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	q := c.acquireQuery()
+	q.Mutex.Lock()
+	_ = c.send(context.Background(), conn.CommandDisconnect, q)
+	q.Mutex.Unlock()
+
+	return nil
+}
+
+// Begin starts and returns a new transaction.
+func (c *Conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts and returns a new transaction with the isolation level and the access mode of opts.
+func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	sb := strings.Builder{}
+	sb.WriteString("begin")
+
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault:
+	case sql.LevelReadUncommitted:
+		sb.WriteString(" isolation level read uncommitted")
+	case sql.LevelReadCommitted:
+		sb.WriteString(" isolation level read committed")
+	case sql.LevelRepeatableRead, sql.LevelSnapshot:
+		sb.WriteString(" isolation level repeatable read")
+	case sql.LevelSerializable:
+		sb.WriteString(" isolation level serializable")
+	default:
+		return nil, errors.New("unsupported isolation: " + strconv.Itoa(int(opts.Isolation)))
+	}
+
+	if opts.ReadOnly {
+		sb.WriteString(" read only")
+	}
+
+	_, err := c.exec(ctx, sb.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{conn: c}, nil
+}
+
+// ExecContext executes a query that doesn't return rows.
+func (c *Conn) ExecContext(ctx context.Context, query string, argsV []driver.NamedValue) (driver.Result, error) {
+	args, err := namedValuesToArgs(argsV)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.exec(ctx, query, args)
+}
+
+// QueryContext executes a query that may return rows.
+func (c *Conn) QueryContext(ctx context.Context, query string, argsV []driver.NamedValue) (driver.Rows, error) {
+	args, err := namedValuesToArgs(argsV)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := c.query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRows(c, q), nil
+}
+
+// CheckNamedValue accepts every value, the conversion is done by pgtype while encoding the parameters.
+func (c *Conn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+// Tx is a transaction of a Conn.
+type Tx struct {
+	conn *Conn
+}
+
+// Commit commits the transaction.
+func (t *Tx) Commit() error {
+	_, err := t.conn.exec(context.Background(), "commit", nil)
+	return err
+}
+
+// Rollback aborts the transaction.
+func (t *Tx) Rollback() error {
+	_, err := t.conn.exec(context.Background(), "rollback", nil)
+	return err
+}
+
+// Stmt is a prepared statement of a Conn.
+type Stmt struct {
+	sql    string
+	d      *conn.Description
+	conn   *Conn
+	closed bool
+}
+
+// Close deallocates the prepared statement.
+func (s *Stmt) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.conn.deallocate(s.d)
+}
+
+// NumInput returns -1, database/sql does not check the argument count then.
+func (s *Stmt) NumInput() int {
+	return -1
+}
+
+// Exec executes the prepared statement.
+func (s *Stmt) Exec(argsV []driver.Value) (driver.Result, error) {
+	q, err := s.conn.queryDescription(context.Background(), s.d, s.sql, valuesToArgs(argsV))
+	if err != nil {
+		return nil, err
+	}
+	return execResult(q), nil
+}
+
+// Query executes the prepared statement.
+func (s *Stmt) Query(argsV []driver.Value) (driver.Rows, error) {
+	q, err := s.conn.queryDescription(context.Background(), s.d, s.sql, valuesToArgs(argsV))
+	if err != nil {
+		return nil, err
+	}
+
+	return newRows(s.conn, q), nil
+}
+
+// ExecContext executes the prepared statement.
+func (s *Stmt) ExecContext(ctx context.Context, argsV []driver.NamedValue) (driver.Result, error) {
+	args, err := namedValuesToArgs(argsV)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := s.conn.queryDescription(ctx, s.d, s.sql, args)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(q), nil
+}
+
+// QueryContext executes the prepared statement.
+func (s *Stmt) QueryContext(ctx context.Context, argsV []driver.NamedValue) (driver.Rows, error) {
+	args, err := namedValuesToArgs(argsV)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := s.conn.queryDescription(ctx, s.d, s.sql, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRows(s.conn, q), nil
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package stdlib
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"pap/internal/conn"
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+// Rows is an iterator over the received rows of a query. The values are decoded by pgtype and converted by
+// pgtype.DatabaseSQLValue.
+type Rows struct {
+	conn    *Conn
+	q       *conn.Query
+	fields  []pgproto.FieldDescription
+	values  [][]byte
+	row     int
+	rows    int
+	columns int
+	closed  bool
+}
+
+func newRows(c *Conn, q *conn.Query) *Rows {
+	r := &Rows{
+		conn:    c,
+		q:       q,
+		fields:  q.D.FieldDescriptions,
+		values:  q.R.RowValues(),
+		columns: len(q.D.FieldDescriptions),
+	}
+	if r.columns > 0 {
+		r.rows = len(r.values) / r.columns
+	}
+	return r
+}
+
+// Columns returns the names of the columns.
+func (r *Rows) Columns() []string {
+	names := make([]string, len(r.fields))
+	for i := range r.fields {
+		names[i] = string(r.fields[i].Name)
+	}
+	return names
+}
+
+// ColumnTypeDatabaseTypeName returns the upper-case name of the column type, e.g. "INT8". The OID is returned for
+// types not registered on ConnInfo.
+func (r *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	if dt, ok := r.conn.connInfo.DataTypeForOID(r.fields[index].DataTypeOID); ok {
+		return strings.ToUpper(dt.Name)
+	}
+
+	return strconv.FormatInt(int64(r.fields[index].DataTypeOID), 10)
+}
+
+// Close releases the query, the rows must not be used after.
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.q.Close()
+	return nil
+}
+
+// Next decodes the next row into dest.
+func (r *Rows) Next(dest []driver.Value) error {
+	if r.row >= r.rows {
+		return io.EOF
+	}
+
+	values := r.values[r.row*r.columns : (r.row+1)*r.columns]
+	for i := range values {
+		v, err := r.decode(&r.fields[i], values[i])
+		if err != nil {
+			return err
+		}
+		dest[i] = v
+	}
+	r.row++
+
+	return nil
+}
+
+func (r *Rows) decode(fd *pgproto.FieldDescription, src []byte) (driver.Value, error) {
+	if src == nil {
+		return nil, nil
+	}
+
+	ci := r.conn.connInfo
+	dt, ok := ci.DataTypeForOID(fd.DataTypeOID)
+	if !ok {
+		if fd.Format == conn.TextFormatCode {
+			return string(src), nil
+		}
+		buf := make([]byte, len(src))
+		copy(buf, src)
+		return buf, nil
+	}
+
+	value := pgtype.NewValue(dt.Value)
+	switch fd.Format {
+	case conn.BinaryFormatCode:
+		decoder, ok := value.(pgtype.BinaryDecoder)
+		if !ok {
+			return nil, fmt.Errorf("%s does not support binary format", dt.Name)
+		}
+		if err := decoder.DecodeBinary(ci, src); err != nil {
+			return nil, err
+		}
+	case conn.TextFormatCode:
+		decoder, ok := value.(pgtype.TextDecoder)
+		if !ok {
+			return nil, fmt.Errorf("%s does not support text format", dt.Name)
+		}
+		if err := decoder.DecodeText(ci, src); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format code %d", fd.Format)
+	}
+
+	return pgtype.DatabaseSQLValue(ci, value)
+}
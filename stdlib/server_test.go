@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package stdlib
+
+import (
+	"database/sql"
+	"net"
+	"sync"
+	"testing"
+
+	"pap/internal/cfg"
+	"pap/internal/pgproto"
+)
+
+// SQL handled specially by the fake server
+const (
+	sqlDrop  = "select 'drop'"        // closes the connection
+	sqlSleep = "select pg_sleep(10)"  // runs until it is canceled
+	sqlMood  = "select 'happy'::mood" // returns its SQL as the type moodOID
+)
+
+const moodOID = 90000
+
+// fakeServer is a PostgreSQL server for tests. A statement returns one text row with its SQL.
+type fakeServer struct {
+	addr   string
+	cancel chan struct{} // receives the cancel requests
+
+	mutex  sync.Mutex
+	conns  int      // the number of accepted connections
+	closed []string // the names of the closed statements
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeServer{addr: ln.Addr().String(), cancel: make(chan struct{}, 1)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) config(t *testing.T) *cfg.Config {
+	var config cfg.Config
+	if err := config.ParseConfig("postgres://u@" + s.addr + "/db?sslmode=disable"); err != nil {
+		t.Fatal(err)
+	}
+	return &config
+}
+
+// openDB opens a database with one connection to the server.
+func (s *fakeServer) openDB(t *testing.T) *sql.DB {
+	return s.openDBFromConfig(t, s.config(t))
+}
+
+func (s *fakeServer) openDBFromConfig(t *testing.T, config *cfg.Config) *sql.DB {
+	db := OpenDBFromConfig(config)
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	b := pgproto.NewBackend(pgproto.NewChunkReader(c), c)
+	msg, err := b.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+	if _, ok := msg.(*pgproto.CancelRequest); ok {
+		s.cancel <- struct{}{}
+		return
+	}
+
+	s.mutex.Lock()
+	s.conns++
+	s.mutex.Unlock()
+	b.Send(&pgproto.AuthenticationOk{})
+	b.Send(&pgproto.BackendKeyData{ProcessID: 1, SecretKey: 2})
+	b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+
+	fields := func(sql string) []pgproto.FieldDescription {
+		oid := uint32(25)
+		if sql == sqlMood {
+			oid = moodOID
+		}
+		return []pgproto.FieldDescription{{Name: []byte("sql"), DataTypeOID: oid, DataTypeSize: -1, TypeModifier: -1}}
+	}
+	statements := make(map[string]string)
+	var portal string
+	for {
+		msg, err := b.Receive()
+		if err != nil {
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto.Parse:
+			statements[msg.Name] = msg.Query
+			b.Send(&pgproto.ParseComplete{})
+		case *pgproto.Describe:
+			if msg.ObjectType == 'S' {
+				b.Send(&pgproto.ParameterDescription{})
+				b.Send(&pgproto.RowDescription{Fields: fields(statements[msg.Name])})
+			} else {
+				b.Send(&pgproto.RowDescription{Fields: fields(portal)})
+			}
+		case *pgproto.Bind:
+			portal = statements[msg.PreparedStatement]
+			b.Send(&pgproto.BindComplete{})
+		case *pgproto.Execute:
+			switch portal {
+			case sqlDrop:
+				return
+			case sqlSleep:
+				<-s.cancel
+				b.Send(&pgproto.ErrorResponse{Severity: "ERROR", Code: "57014", Message: "canceling statement due to user request"})
+			default:
+				b.Send(&pgproto.DataRow{Values: [][]byte{[]byte(portal)}})
+				b.Send(&pgproto.CommandComplete{CommandTag: []byte("SELECT 1")})
+			}
+		case *pgproto.Close:
+			s.mutex.Lock()
+			s.closed = append(s.closed, msg.Name)
+			s.mutex.Unlock()
+			b.Send(&pgproto.CloseComplete{})
+		case *pgproto.Sync:
+			b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto.Terminate:
+			return
+		}
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package stdlib
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"pap/internal/pgtype"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	connString := os.Getenv("PAP_TEST_DATABASE")
+	if connString == "" {
+		t.Skip("PAP_TEST_DATABASE is not set")
+	}
+
+	db, err := sql.Open("pap", connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestNamedValuesToArgs(t *testing.T) {
+	args, err := namedValuesToArgs([]driver.NamedValue{{Ordinal: 1, Value: int64(1)}, {Ordinal: 2, Value: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != int64(1) || args[1] != "a" {
+		t.Fatalf("unexpected args %v", args)
+	}
+
+	_, err = namedValuesToArgs([]driver.NamedValue{{Name: "a", Ordinal: 1, Value: int64(1)}})
+	if err != errNamedArgs {
+		t.Fatalf("expected %v, got %v", errNamedArgs, err)
+	}
+}
+
+func TestQueryRows(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	rows, err := db.Query("select n, n::text from generate_series(1, $1::int4) n", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columnTypes[0].DatabaseTypeName() != "INT4" || columnTypes[1].DatabaseTypeName() != "TEXT" {
+		t.Fatalf("unexpected column types %s, %s", columnTypes[0].DatabaseTypeName(), columnTypes[1].DatabaseTypeName())
+	}
+
+	var count int64
+	for rows.Next() {
+		var n int64
+		var s string
+		if err := rows.Scan(&n, &s); err != nil {
+			t.Fatal(err)
+		}
+		count++
+		if n != count {
+			t.Fatalf("expected %d, got %d", count, n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 rows, got %d", count)
+	}
+}
+
+func TestTxExec(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("create temporary table pap_stdlib_test(id int8)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tx.Exec("insert into pap_stdlib_test select generate_series(1, $1::int4)", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 rows affected, got %d", n)
+	}
+}
+
+func TestStmtCloseDeallocates(t *testing.T) {
+	s := newFakeServer(t)
+	db := s.openDB(t)
+
+	stmt, err := db.Prepare("select 'stmt'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if err := stmt.QueryRow().Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	if value != "select 'stmt'" {
+		t.Errorf("unexpected value %q", value)
+	}
+	if err := stmt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.closed) != 1 || s.closed[0] != "pap_stdlib_ps_0" {
+		t.Errorf("expected the statement to be deallocated, closed %v", s.closed)
+	}
+}
+
+func TestBrokenConnDiscarded(t *testing.T) {
+	s := newFakeServer(t)
+	db := s.openDB(t)
+
+	if _, err := db.Exec(sqlDrop); err == nil {
+		t.Fatal("expected error for the lost connection")
+	}
+
+	var value string
+	if err := db.QueryRow("select 'next'").Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conns != 2 {
+		t.Errorf("expected the broken connection to be replaced, got %d connections", s.conns)
+	}
+}
+
+func TestQueryContextCancel(t *testing.T) {
+	s := newFakeServer(t)
+	db := s.openDB(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.QueryContext(ctx, sqlSleep); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// the canceled query does not break the connection
+	var value string
+	if err := db.QueryRow("select 'next'").Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conns != 1 {
+		t.Errorf("expected the connection to be reused, got %d connections", s.conns)
+	}
+}
+
+func TestOpenDBFromConfigConnInfo(t *testing.T) {
+	s := newFakeServer(t)
+	config := s.config(t)
+	config.ConnInfo = pgtype.NewConnInfo()
+	config.ConnInfo.RegisterDataType(pgtype.DataType{Value: pgtype.NewEnumType("mood", []string{"sad", "happy"}), Name: "mood", OID: moodOID})
+	db := s.openDBFromConfig(t, config)
+
+	rows, err := db.Query(sqlMood)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := columnTypes[0].DatabaseTypeName(); name != "MOOD" {
+		t.Errorf("expected the registered type MOOD, got %s", name)
+	}
+
+	var mood string
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := rows.Scan(&mood); err != nil {
+		t.Fatal(err)
+	}
+	if mood != sqlMood {
+		t.Errorf("unexpected value %q", mood)
+	}
+}
+
+// TestConnectContext connects to a server which never answers the startup message. ctx ends the connect and the
+// socket is closed.
+func TestConnectContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	closed := make(chan struct{})
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = io.Copy(io.Discard, c)
+		close(closed)
+	}()
+
+	connector, err := papDriver.OpenConnector("postgres://u@" + ln.Addr().String() + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := connector.Connect(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connect does not end with ctx")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("the socket is not closed")
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pap/internal/conn"
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+// sqlHang is never answered by hangServer.
+const sqlHang = "select 'hang'"
+
+// hangServer returns the config of a fake server that reads without answering after sqlHang, other queries return 1.
+// connected counts the connections.
+func hangServer(t *testing.T, connected *int32) *Config {
+	addr := fakeServer(t, nil, func(b *pgproto.Backend) {
+		atomic.AddInt32(connected, 1)
+		serveQueries(b, func(sql string, args [][]byte) fakeResult {
+			if sql == sqlHang {
+				return fakeResult{serve: func(b *pgproto.Backend) {
+					for {
+						if _, err := b.Receive(); err != nil {
+							return
+						}
+					}
+				}}
+			}
+			return fakeResult{oids: []uint32{pgtype.Int4OID}, rows: [][]interface{}{{int32(1)}}}
+		})
+	})
+	config, err := ParseConfig("postgres://u@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// TestReadTimeout checks that a connection without response fails with ErrReadTimeout and is reconnected.
+func TestReadTimeout(t *testing.T) {
+	var connected int32
+	config := hangServer(t, &connected)
+	config.ReadTimeout = 100 * time.Millisecond
+	p, err := StartConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []struct {
+		N int32
+	}
+	start := time.Now()
+	if err := p.QueryAsync(sqlHang)(&rows); !errors.Is(err, conn.ErrReadTimeout) {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("read timeout after %s", elapsed)
+	}
+
+	// the failed connection is reconnected, all connections answer again
+	for i := 0; i < 2*max; i++ {
+		if err := p.QueryAsync("select 1")(&rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&connected) <= 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed connection is not reconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestReadTimeoutContextDeadline checks that the read timeout does not extend the deadline of the context passed to
+// AfterConnect.
+func TestReadTimeoutContextDeadline(t *testing.T) {
+	var connected int32
+	config := hangServer(t, &connected)
+	config.ReadTimeout = time.Minute
+	config.AfterConnect = func(ctx context.Context, s Session) error {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err := s.Exec(ctx, sqlHang)
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := connectCheck(nil, config)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("expected a timeout of the context deadline, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the read timeout overrides the context deadline")
+	}
+}
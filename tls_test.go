@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pap/internal/conn"
+	"pap/internal/pgproto"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and the path of its PEM file for sslrootcert.
+func testCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pap test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "root.crt")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, path
+}
+
+// tlsServer is a fake server answering SSLRequest with sslResponse: 'S' starts TLS, 'N' refuses it and 'F' fails the
+// handshake by closing the connection. The startup messages are rejected like by pg_hba.conf if rejectTLS or
+// rejectPlain matches, otherwise they are authenticated by auth, see fakeServer.
+type tlsServer struct {
+	addr        string
+	cert        tls.Certificate
+	sslResponse byte
+	rejectTLS   bool
+	rejectPlain bool
+	auth        func(b *pgproto.Backend) bool
+
+	mutex    sync.Mutex
+	startups []bool // whether each received startup message was sent over TLS
+}
+
+func newTLSServer(t *testing.T, cert tls.Certificate, sslResponse byte) *tlsServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &tlsServer{addr: ln.Addr().String(), cert: cert, sslResponse: sslResponse}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *tlsServer) serve(c net.Conn) {
+	defer func() { c.Close() }()
+	b := pgproto.NewBackend(pgproto.NewChunkReader(c), c)
+	msg, err := b.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+
+	overTLS := false
+	if _, ok := msg.(*pgproto.SSLRequest); ok {
+		switch s.sslResponse {
+		case 'F':
+			return
+		case 'N':
+			if _, err := c.Write([]byte{'N'}); err != nil {
+				return
+			}
+		case 'S':
+			if _, err := c.Write([]byte{'S'}); err != nil {
+				return
+			}
+			tlsConn := tls.Server(c, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			c = tlsConn
+			overTLS = true
+			b = pgproto.NewBackend(pgproto.NewChunkReader(c), c)
+		}
+		if msg, err = b.ReceiveStartupMessage(); err != nil {
+			return
+		}
+	}
+	if _, ok := msg.(*pgproto.StartupMessage); !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	s.startups = append(s.startups, overTLS)
+	s.mutex.Unlock()
+
+	if overTLS && s.rejectTLS || !overTLS && s.rejectPlain {
+		b.Send(&pgproto.ErrorResponse{Severity: "FATAL", Code: "28000", Message: "no pg_hba.conf entry"})
+		return
+	}
+	if s.auth != nil && !s.auth(b) {
+		return
+	}
+	b.Send(&pgproto.AuthenticationOk{})
+	b.Send(&pgproto.ReadyForQuery{TxStatus: 'I'})
+	for {
+		if _, err := b.Receive(); err != nil {
+			return
+		}
+	}
+}
+
+// connect connects with the sslmode and returns the error and the received startup messages.
+func (s *tlsServer) connect(t *testing.T, params string) ([]bool, error) {
+	config, err := ParseConfig("postgres://u@" + s.addr + "/db?" + params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := connectCheck(nil, config)
+	if err == nil {
+		cc.close()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	startups := s.startups
+	s.startups = nil
+	return startups, err
+}
+
+// TestTLSRequired checks that sslmode=require and verify-full never send the startup message without TLS.
+func TestTLSRequired(t *testing.T) {
+	cert, rootCert := testCertificate(t)
+	otherCert, _ := testCertificate(t)
+
+	tests := []struct {
+		name        string
+		sslResponse byte
+		cert        tls.Certificate
+		params      string
+	}{
+		{"require refused", 'N', cert, "sslmode=require"},
+		{"require handshake failure", 'F', cert, "sslmode=require"},
+		{"verify-full refused", 'N', cert, "sslmode=verify-full&sslrootcert=" + rootCert},
+		{"verify-full handshake failure", 'F', cert, "sslmode=verify-full&sslrootcert=" + rootCert},
+		{"verify-full unknown certificate", 'S', otherCert, "sslmode=verify-full&sslrootcert=" + rootCert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTLSServer(t, tt.cert, tt.sslResponse)
+			startups, err := s.connect(t, tt.params)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if len(startups) > 0 {
+				t.Errorf("startup message sent after a failed TLS negotiation: %v", startups)
+			}
+		})
+	}
+
+	s := newTLSServer(t, cert, 'S')
+	if startups, err := s.connect(t, "sslmode=verify-full&sslrootcert="+rootCert); err != nil || !reflect.DeepEqual(startups, []bool{true}) {
+		t.Errorf("expected a TLS connection, got %v, %v", startups, err)
+	}
+}
+
+func TestTLSRefused(t *testing.T) {
+	cert, _ := testCertificate(t)
+	s := newTLSServer(t, cert, 'N')
+	_, err := s.connect(t, "sslmode=require")
+	if !errors.Is(err, conn.ErrTLSRefused) {
+		t.Fatalf("expected ErrTLSRefused, got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "tls error with sslmode=require") || !strings.Contains(msg, "server refused TLS connection") {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+// TestTLSFallback checks the retries of sslmode=prefer and allow with the other TLS setting.
+func TestTLSFallback(t *testing.T) {
+	cert, _ := testCertificate(t)
+
+	tests := []struct {
+		name        string
+		sslResponse byte
+		rejectTLS   bool
+		rejectPlain bool
+		params      string
+		startups    []bool
+	}{
+		{"prefer", 'S', false, false, "sslmode=prefer", []bool{true}},
+		{"prefer refused", 'N', false, false, "sslmode=prefer", []bool{false}},
+		{"prefer handshake failure", 'F', false, false, "sslmode=prefer", []bool{false}},
+		{"prefer rejected with TLS", 'S', true, false, "sslmode=prefer", []bool{true, false}},
+		{"allow", 'S', false, false, "sslmode=allow", []bool{false}},
+		{"allow rejected without TLS", 'S', false, true, "sslmode=allow", []bool{false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTLSServer(t, cert, tt.sslResponse)
+			s.rejectTLS, s.rejectPlain = tt.rejectTLS, tt.rejectPlain
+			startups, err := s.connect(t, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(startups, tt.startups) {
+				t.Errorf("expected startup messages %v, got %v", tt.startups, startups)
+			}
+		})
+	}
+
+	// both rejected, no further attempts
+	s := newTLSServer(t, cert, 'S')
+	s.rejectTLS, s.rejectPlain = true, true
+	if startups, err := s.connect(t, "sslmode=prefer"); err == nil || !reflect.DeepEqual(startups, []bool{true, false}) {
+		t.Errorf("expected the rejection after two attempts, got %v, %v", startups, err)
+	}
+}
+
+// TestChannelBindingRequire checks that channel_binding=require refuses the authentication requests without channel
+// binding before any password is sent.
+func TestChannelBindingRequire(t *testing.T) {
+	cert, _ := testCertificate(t)
+
+	tests := []struct {
+		name     string
+		request  pgproto.BackendMessage
+		authType uint32
+	}{
+		{"cleartext", &pgproto.AuthenticationCleartextPassword{}, pgproto.AuthTypeCleartextPassword},
+		{"md5", &pgproto.AuthenticationMD5Password{Salt: [4]byte{1, 2, 3, 4}}, pgproto.AuthTypeMD5Password},
+		{"scram without plus", &pgproto.AuthenticationSASL{AuthMechanisms: []string{"SCRAM-SHA-256"}}, pgproto.AuthTypeSASL},
+		{"trust", nil, 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTLSServer(t, cert, 'S')
+			passwordSent := make(chan pgproto.FrontendMessage, 1)
+			s.auth = func(b *pgproto.Backend) bool {
+				if tt.request == nil {
+					return true
+				}
+				b.Send(tt.request)
+				b.SetAuthType(tt.authType)
+				if msg, err := b.Receive(); err == nil {
+					passwordSent <- msg
+				}
+				return false
+			}
+
+			config, err := ParseConfig("postgres://u:secret@" + s.addr + "/db?sslmode=require&channel_binding=require")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := connectCheck(nil, config); err == nil {
+				t.Fatal("expected error")
+			}
+			select {
+			case msg := <-passwordSent:
+				t.Errorf("password sent: %T", msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"errors"
+
+	"pap/internal/conn"
+)
+
+var (
+	ErrTxClosed         = errors.New("tx is closed")
+	ErrTxCommitRollback = errors.New("commit unexpectedly resulted in rollback")
+)
+
+// Tx is a transaction. It is pinned to one connection of the pool, which is not used by other queries until the
+// transaction ends. A Tx must not be used concurrently.
+type Tx struct {
+	p      *Pap
+	number int
+	closed bool
+}
+
+// Begin starts a transaction on a ready connection of the pool. ctx limits the waiting for a connection.
+func (p *Pap) Begin(ctx context.Context) (*Tx, error) {
+	actx, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	eq, err := p.startQuery(actx, false, "begin")
+	if err != nil {
+		release()
+		return nil, err
+	}
+	number, err := p.dispatchQuery(actx, false, conn.Command{
+		CommandType: conn.CommandSimpleQuery,
+		Query:       eq,
+		Pin:         true,
+	})
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &Tx{p: p, number: number}
+	if _, err := tx.result(eq); err != nil {
+		// release the connection
+		_ = tx.rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// Exec executes sql with the simple query protocol in the transaction.
+func (tx *Tx) Exec(ctx context.Context, sql string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tx.closed {
+		return ErrTxClosed
+	}
+
+	_, err := tx.exec(ctx, sql, false)
+	return err
+}
+
+// Commit commits the transaction and releases its connection. ErrTxCommitRollback is returned if the transaction
+// failed before and was rolled back. The transaction stays open if no query is acquired before ctx is done.
+func (tx *Tx) Commit(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tx.closed {
+		return ErrTxClosed
+	}
+
+	commandTag, err := tx.exec(ctx, "commit", true)
+	if err != nil {
+		return err
+	}
+	if commandTag == "ROLLBACK" {
+		return ErrTxCommitRollback
+	}
+	return nil
+}
+
+// Rollback rolls the transaction back and releases its connection. ErrTxClosed is returned if the transaction is
+// already committed or rolled back, so it is safe to defer Rollback. ctx is not used, the connection is released even
+// if ctx is done.
+func (tx *Tx) Rollback(ctx context.Context) error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+
+	return tx.rollback()
+}
+
+// rollback rolls tx back on its connection and closes tx. The query waits without the AcquireTimeout and is not
+// counted by the queue limit, so the connection is unpinned even if the pool is exhausted or the rollback fails.
+func (tx *Tx) rollback() error {
+	tx.closed = true
+	eq, err := tx.p.startQuery(context.Background(), false, "rollback")
+	if err != nil {
+		return err
+	}
+
+	tx.p.conns.list[tx.number].sendPinned(conn.Command{
+		CommandType: conn.CommandSimpleQuery,
+		Query:       eq,
+		Unpin:       true,
+	})
+	_, err = tx.result(eq)
+	return err
+}
+
+// exec executes sql on the connection of tx and returns the command tag. unpin is set for the last command of the
+// transaction, tx is closed once its query is acquired. ctx limits the waiting for the query.
+func (tx *Tx) exec(ctx context.Context, sql string, unpin bool) (string, error) {
+	ctx, cancel := tx.p.acquireContext(ctx)
+	eq, err := tx.p.startQuery(ctx, false, sql)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	if unpin {
+		tx.closed = true
+	}
+
+	tx.p.conns.list[tx.number].sendPinned(conn.Command{
+		CommandType: conn.CommandSimpleQuery,
+		Query:       eq,
+		Unpin:       unpin,
+	})
+	return tx.result(eq)
+}
+
+// result waits for the result of eq and returns its command tag.
+func (tx *Tx) result(eq *conn.Query) (string, error) {
+	eq.Mutex.Lock()
+	defer eq.Close()
+	if err := eq.R.Error(); err != nil {
+		return "", err
+	}
+	return string(eq.R.CommandTag()), nil
+}
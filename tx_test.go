@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pap/internal/conn"
+	"pap/internal/pgproto"
+	"pap/internal/pgtype"
+)
+
+// txServer starts a pool on a fake server which counts the statements executed inside a transaction by the extended
+// protocol, which the transactions of Tx never use.
+func txServer(t *testing.T) (p *Pap, leaked *int32) {
+	leaked = new(int32)
+	addr := fakeServer(t, nil, func(b *pgproto.Backend) {
+		inTx := false
+		serveQueries(b, func(sql string, args [][]byte) fakeResult {
+			switch sql {
+			case "begin":
+				inTx = true
+				return fakeResult{tag: "BEGIN"}
+			case "commit", "rollback":
+				inTx = false
+				return fakeResult{tag: "COMMIT"}
+			}
+			if inTx && args != nil {
+				atomic.AddInt32(leaked, 1)
+			}
+			return fakeResult{oids: []uint32{pgtype.Int4OID}, rows: [][]interface{}{{int32(1)}}}
+		})
+	})
+
+	p, err := Start("postgres://u@" + addr + "/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, leaked
+}
+
+// TestBeginPrepare begins transactions while a new statement is prepared on every connection. The connections
+// announce themselves after preparing, which must not dispatch queries into the transactions.
+func TestBeginPrepare(t *testing.T) {
+	p, leaked := txServer(t)
+
+	for round := 0; round < 5; round++ {
+		var wg sync.WaitGroup
+		txs := make([]*Tx, 3)
+		errs := make([]error, len(txs)+1)
+		wg.Add(len(txs) + 1)
+		go func() {
+			defer wg.Done()
+			var rows []struct {
+				N int32
+			}
+			errs[len(txs)] = p.QueryAsync("select " + strconv.Itoa(round))(&rows)
+		}()
+		for i := range txs {
+			go func(i int) {
+				defer wg.Done()
+				txs[i], errs[i] = p.Begin(context.Background())
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < 2*max; i++ {
+			var rows []struct {
+				N int32
+			}
+			if err := p.QueryAsync("select 1")(&rows); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tx := range txs {
+			if err := tx.Commit(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if n := atomic.LoadInt32(leaked); n > 0 {
+		t.Errorf("%d queries of the pool were executed in a transaction", n)
+	}
+}
+
+// TestRollbackExhausted rolls back a transaction while no query is free beyond the AcquireTimeout. The rollback waits
+// for a query and releases the connection.
+func TestRollbackExhausted(t *testing.T) {
+	p, _ := txServer(t)
+	tx, err := p.Begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.config.AcquireTimeout = time.Millisecond
+	var held []*conn.Query
+	for len(p.emptyQueryChan) > 0 {
+		held = append(held, <-p.emptyQueryChan)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		for _, eq := range held {
+			p.emptyQueryChan <- eq
+		}
+	}()
+
+	if err := tx.Rollback(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(context.Background()); err != ErrTxClosed {
+		t.Errorf("expected ErrTxClosed, got %v", err)
+	}
+
+	c := &p.conns.list[tx.number]
+	c.mutex.Lock()
+	pinned := c.pinned
+	c.mutex.Unlock()
+	if pinned {
+		t.Error("expected the connection to be unpinned")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"fmt"
+
+	"pap/internal/pgtype"
+)
+
+type pgType struct {
+	Name        string
+	TypType     string
+	TypCategory string
+	TypElem     uint32
+	TypRelID    uint32
+	TypBaseType uint32
+}
+
+type pgTypeOID struct {
+	OID uint32
+}
+
+type pgEnumMember struct {
+	Label string
+}
+
+// RegisterDataType registers t on the ConnInfo used by every query of p. The ConnInfo is read without locking by
+// running queries, so RegisterDataType must only be called before any queries run.
+func (p *Pap) RegisterDataType(t pgtype.DataType) {
+	p.queries.connInfo.RegisterDataType(t)
+}
+
+// LoadType inspects the database for typeName, registers the found data type and returns it. Enum, composite, array
+// and domain types are supported. Not registered element, field and base types are loaded too. ctx limits the
+// catalog queries.
+//
+// The types are registered like by RegisterDataType, so LoadType must only be called before any other queries run.
+func (p *Pap) LoadType(ctx context.Context, typeName string) (pgtype.DataType, error) {
+	var oids []pgTypeOID
+	err := p.QueryAsyncContext(ctx, "select $1::text::regtype::oid", typeName)(&oids)
+	if err != nil {
+		return pgtype.DataType{}, err
+	}
+	if len(oids) == 0 {
+		return pgtype.DataType{}, fmt.Errorf("type %s not found", typeName)
+	}
+
+	dt, err := p.loadDataType(ctx, oids[0].OID, typeName)
+	if err != nil {
+		return pgtype.DataType{}, err
+	}
+	p.RegisterDataType(dt)
+
+	return dt, nil
+}
+
+// dataTypeForOID returns the registered data type for oid, loading it from the database when it is not registered.
+func (p *Pap) dataTypeForOID(ctx context.Context, oid uint32) (*pgtype.DataType, error) {
+	if dt, ok := p.queries.connInfo.DataTypeForOID(oid); ok {
+		return dt, nil
+	}
+
+	dt, err := p.loadDataType(ctx, oid, "")
+	if err != nil {
+		return nil, err
+	}
+	p.RegisterDataType(dt)
+
+	return &dt, nil
+}
+
+// loadDataType builds the data type for oid from pg_type. The type name from the catalog is used if typeName is empty.
+func (p *Pap) loadDataType(ctx context.Context, oid uint32, typeName string) (pgtype.DataType, error) {
+	var types []pgType
+	err := p.QueryAsyncContext(
+		ctx,
+		"select typname::text, typtype::text, typcategory::text, typelem, typrelid, typbasetype from pg_type where oid = $1",
+		oid,
+	)(&types)
+	if err != nil {
+		return pgtype.DataType{}, err
+	}
+	if len(types) == 0 {
+		return pgtype.DataType{}, fmt.Errorf("type with oid %d not found", oid)
+	}
+
+	t := types[0]
+	if typeName == "" {
+		typeName = t.Name
+	}
+
+	switch t.TypType {
+	case "b": // array
+		// base types like point or oidvector have an element type too, but are not arrays
+		if t.TypCategory != "A" {
+			return pgtype.DataType{}, fmt.Errorf("base type %s is not supported", typeName)
+		}
+
+		elementDT, err := p.dataTypeForOID(ctx, t.TypElem)
+		if err != nil {
+			return pgtype.DataType{}, err
+		}
+
+		element, ok := elementDT.Value.(pgtype.ValueTranscoder)
+		if !ok {
+			return pgtype.DataType{}, fmt.Errorf("array element %s is not a ValueTranscoder", elementDT.Name)
+		}
+
+		newElement := func() pgtype.ValueTranscoder {
+			return pgtype.NewValue(element).(pgtype.ValueTranscoder)
+		}
+
+		at := pgtype.NewArrayType(typeName, t.TypElem, newElement)
+		return pgtype.DataType{Value: at, Name: typeName, OID: oid}, nil
+	case "c": // composite
+		var fields []pgtype.CompositeTypeField
+		err := p.QueryAsyncContext(
+			ctx,
+			`select attname::text, atttypid
+from pg_attribute
+where attrelid = $1 and attnum > 0 and not attisdropped
+order by attnum`,
+			t.TypRelID,
+		)(&fields)
+		if err != nil {
+			return pgtype.DataType{}, err
+		}
+
+		for i := range fields {
+			if _, err := p.dataTypeForOID(ctx, fields[i].OID); err != nil {
+				return pgtype.DataType{}, err
+			}
+		}
+
+		ct, err := pgtype.NewCompositeType(typeName, fields, p.queries.connInfo)
+		if err != nil {
+			return pgtype.DataType{}, err
+		}
+		return pgtype.DataType{Value: ct, Name: typeName, OID: oid}, nil
+	case "e": // enum
+		var members []pgEnumMember
+		err := p.QueryAsyncContext(
+			ctx,
+			"select enumlabel::text from pg_enum where enumtypid = $1 order by enumsortorder",
+			oid,
+		)(&members)
+		if err != nil {
+			return pgtype.DataType{}, err
+		}
+
+		labels := make([]string, len(members))
+		for i := range members {
+			labels[i] = members[i].Label
+		}
+		return pgtype.DataType{Value: pgtype.NewEnumType(typeName, labels), Name: typeName, OID: oid}, nil
+	case "d": // domain
+		baseDT, err := p.dataTypeForOID(ctx, t.TypBaseType)
+		if err != nil {
+			return pgtype.DataType{}, err
+		}
+		return pgtype.DataType{Value: pgtype.NewValue(baseDT.Value), Name: typeName, OID: oid}, nil
+	default:
+		return pgtype.DataType{}, fmt.Errorf("unsupported typtype %s of %s", t.TypType, typeName)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2021-2022 UNNG Lab.
+ */
+
+package pap
+
+import (
+	"context"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+
+	"pap/internal/pgtype"
+)
+
+// fakeCatalog answers the catalog queries of LoadType for an enum mood with its array type, and the base type point,
+// which has an element type but is not an array.
+func fakeCatalog(sql string, args [][]byte) fakeResult {
+	oidArg := func(arg []byte) uint32 {
+		if len(arg) == 4 {
+			return binary.BigEndian.Uint32(arg)
+		}
+		oid, _ := strconv.ParseUint(string(arg), 10, 32)
+		return uint32(oid)
+	}
+
+	switch {
+	case strings.Contains(sql, "regtype"):
+		r := fakeResult{params: []uint32{pgtype.TextOID}, oids: []uint32{pgtype.OIDOID}}
+		if args == nil {
+			return r
+		}
+		oid := map[string]uint32{"mood": 90000, "mood[]": 90001, "point": pgtype.PointOID}[string(args[0])]
+		r.rows = [][]interface{}{{oid}}
+		return r
+	case strings.Contains(sql, "from pg_type"):
+		r := fakeResult{
+			params: []uint32{pgtype.OIDOID},
+			oids:   []uint32{pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.OIDOID, pgtype.OIDOID, pgtype.OIDOID},
+		}
+		if args == nil {
+			return r
+		}
+		switch oidArg(args[0]) {
+		case 90000:
+			r.rows = [][]interface{}{{"mood", "e", "E", uint32(0), uint32(0), uint32(0)}}
+		case 90001:
+			r.rows = [][]interface{}{{"_mood", "b", "A", uint32(90000), uint32(0), uint32(0)}}
+		case pgtype.PointOID:
+			r.rows = [][]interface{}{{"point", "b", "G", uint32(pgtype.Float8OID), uint32(0), uint32(0)}}
+		}
+		return r
+	case strings.Contains(sql, "from pg_enum"):
+		return fakeResult{
+			params: []uint32{pgtype.OIDOID},
+			oids:   []uint32{pgtype.TextOID},
+			rows:   [][]interface{}{{"sad"}, {"ok"}, {"happy"}},
+		}
+	default:
+		return fakeResult{err: "unexpected query " + sql}
+	}
+}
+
+func TestLoadType(t *testing.T) {
+	p := startFakePool(t, fakeCatalog)
+
+	dt, err := p.LoadType(context.Background(), "mood[]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dt.Name != "mood[]" || dt.OID != 90001 {
+		t.Errorf("unexpected data type %s with oid %d", dt.Name, dt.OID)
+	}
+	if _, ok := dt.Value.(*pgtype.ArrayType); !ok {
+		t.Errorf("expected an array type, got %T", dt.Value)
+	}
+	if _, ok := p.queries.connInfo.DataTypeForOID(90000); !ok {
+		t.Error("expected the element type to be registered")
+	}
+
+	if _, err := p.LoadType(context.Background(), "point"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expected error for the base type point, got %v", err)
+	}
+}
+
+func TestStartConfigConnInfo(t *testing.T) {
+	config := fakePoolConfig(t, func(sql string, args [][]byte) fakeResult {
+		return fakeResult{oids: []uint32{90000}, rows: [][]interface{}{{[]byte("happy")}}}
+	})
+	config.ConnInfo = pgtype.NewConnInfo()
+	config.ConnInfo.RegisterDataType(pgtype.DataType{Value: pgtype.NewEnumType("mood", []string{"sad", "happy"}), Name: "mood", OID: 90000})
+
+	p, err := StartConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.queries.connInfo != config.ConnInfo {
+		t.Error("expected the ConnInfo of the config to be used")
+	}
+
+	var moods []struct {
+		Mood string
+	}
+	if err := p.QueryAsync("select 'happy'::mood")(&moods); err != nil {
+		t.Fatal(err)
+	}
+	if len(moods) != 1 || moods[0].Mood != "happy" {
+		t.Errorf("unexpected result %v", moods)
+	}
+}